@@ -11,4 +11,8 @@ var DBCmd = &cobra.Command{
 func init() {
 	DBCmd.AddCommand(dbShareCmd)
 	DBCmd.AddCommand(dbGetCmd)
+	DBCmd.AddCommand(dbListCmd)
+	DBCmd.AddCommand(dbReplayCmd)
+	DBCmd.AddCommand(dbSnapshotCmd)
+	DBCmd.AddCommand(dbRestoreCmd)
 }
@@ -0,0 +1,265 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultPorts are the standard ports of the databases devlink knows.
+var defaultPorts = map[string]int{"postgres": 5432, "mysql": 3306, "redis": 6379, "mongodb": 27017}
+
+// engines lists the detectable engines in probing order.
+var engines = []string{"postgres", "mysql", "redis", "mongodb"}
+
+// probeTimeout bounds each handshake attempt.
+const probeTimeout = 500 * time.Millisecond
+
+// detectedDB is a database found listening on a local port.
+type detectedDB struct {
+	Name      string
+	Engine    string
+	Version   string
+	Port      int
+	Container string // docker container publishing the port, if any
+}
+
+// shareType is the db share --type for the engine.
+func (d detectedDB) shareType() string {
+	if d.Engine == "mongodb" {
+		return "tcp"
+	}
+	return d.Engine
+}
+
+// probeCandidate is a local port that may serve a database.
+type probeCandidate struct {
+	port      int
+	hint      string // engine to try first
+	container string
+}
+
+// detectDatabases probes the default ports and the ports published by
+// running docker containers.
+func detectDatabases() []detectedDB {
+	candidates := dockerCandidates()
+	seen := map[int]bool{}
+	for _, c := range candidates {
+		seen[c.port] = true
+	}
+	for _, engine := range engines {
+		if port := defaultPorts[engine]; !seen[port] {
+			candidates = append(candidates, probeCandidate{port: port, hint: engine})
+		}
+	}
+
+	found := make([]*detectedDB, len(candidates))
+	var wg sync.WaitGroup
+	for i, c := range candidates {
+		wg.Add(1)
+		go func(i int, c probeCandidate) {
+			defer wg.Done()
+			if engine, version, ok := identify("127.0.0.1:"+strconv.Itoa(c.port), c.hint); ok {
+				found[i] = &detectedDB{Engine: engine, Version: version, Port: c.port, Container: c.container}
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	var dbs []detectedDB
+	names := map[string]bool{}
+	for _, d := range found {
+		if d == nil {
+			continue
+		}
+		d.Name = d.Container
+		if d.Name == "" {
+			d.Name = d.Engine
+		}
+		if names[d.Name] {
+			d.Name = fmt.Sprintf("%s-%d", d.Name, d.Port)
+		}
+		names[d.Name] = true
+		dbs = append(dbs, *d)
+	}
+	return dbs
+}
+
+// dockerCandidates lists the TCP ports published on the host by running
+// containers. Without docker there are none.
+func dockerCandidates() []probeCandidate {
+	out, err := exec.Command("docker", "ps", "--format", "{{.Names}}\t{{.Ports}}").Output()
+	if err != nil {
+		return nil
+	}
+	var candidates []probeCandidate
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		name, ports, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		seen := map[int]bool{}
+		// e.g. "0.0.0.0:5433->5432/tcp, [::]:5433->5432/tcp, 6379/tcp"
+		for _, mapping := range strings.Split(ports, ",") {
+			host, target, ok := strings.Cut(strings.TrimSpace(mapping), "->")
+			if !ok || !strings.HasSuffix(target, "/tcp") {
+				continue
+			}
+			port, err := strconv.Atoi(host[strings.LastIndexByte(host, ':')+1:])
+			if err != nil || seen[port] {
+				continue
+			}
+			seen[port] = true
+			hint := ""
+			containerPort, _ := strconv.Atoi(strings.TrimSuffix(target, "/tcp"))
+			for engine, p := range defaultPorts {
+				if p == containerPort {
+					hint = engine
+				}
+			}
+			candidates = append(candidates, probeCandidate{port: port, hint: hint, container: name})
+		}
+	}
+	return candidates
+}
+
+// identify tries each engine's handshake on a fresh connection, starting
+// with hint, and returns the first engine that answers.
+func identify(addr, hint string) (string, string, bool) {
+	order := []string{}
+	if hint != "" {
+		order = append(order, hint)
+	}
+	for _, engine := range engines {
+		if engine != hint {
+			order = append(order, engine)
+		}
+	}
+	for _, engine := range order {
+		conn, err := net.DialTimeout("tcp", addr, probeTimeout)
+		if err != nil {
+			return "", "", false // nothing listening
+		}
+		_ = conn.SetDeadline(time.Now().Add(probeTimeout))
+		version, ok := probes[engine](conn, bufio.NewReader(conn))
+		conn.Close()
+		if ok {
+			return engine, version, true
+		}
+	}
+	return "", "", false
+}
+
+// probes recognize an engine by its handshake and return the server
+// version when the handshake reveals it.
+var probes = map[string]func(net.Conn, *bufio.Reader) (string, bool){
+	"postgres": probePostgres,
+	"mysql":    probeMySQL,
+	"redis":    probeRedis,
+	"mongodb":  probeMongo,
+}
+
+// probePostgres sends an SSLRequest, which only PostgreSQL answers with a
+// single S or N.
+func probePostgres(conn net.Conn, r *bufio.Reader) (string, bool) {
+	req := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 8), pgSSLRequest)
+	if _, err := conn.Write(req); err != nil {
+		return "", false
+	}
+	b, err := r.ReadByte()
+	// a MySQL greeting may start with these bytes too, but not end there
+	return "", err == nil && (b == 'S' || b == 'N') && r.Buffered() == 0
+}
+
+// probeMySQL reads the greeting MySQL sends on connect: protocol version
+// 10 and the server version, or an error packet such as "host not allowed".
+func probeMySQL(conn net.Conn, r *bufio.Reader) (string, bool) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil || hdr[3] != 0 {
+		return "", false
+	}
+	n := int(hdr[0]) | int(hdr[1])<<8 | int(hdr[2])<<16
+	if n < 2 || n > 1024 {
+		return "", false
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", false
+	}
+	switch payload[0] {
+	case 10:
+		version, _ := cstring(payload[1:])
+		return version, true
+	case 0xff:
+		return "", true
+	}
+	return "", false
+}
+
+// probeRedis sends PING, then asks for the version if no auth is needed.
+func probeRedis(conn net.Conn, r *bufio.Reader) (string, bool) {
+	if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+		return "", false
+	}
+	var buf bytes.Buffer
+	typ, _, text, err := readRESP(r, &buf)
+	if err != nil || typ != '+' && typ != '-' {
+		return "", false
+	}
+	if typ == '-' || text != "PONG" {
+		return "", strings.HasPrefix(text, "NOAUTH") || strings.HasPrefix(text, "DENIED")
+	}
+	if _, err := conn.Write([]byte("INFO server\r\n")); err != nil {
+		return "", true
+	}
+	buf.Reset()
+	if _, _, info, err := readRESP(r, &buf); err == nil {
+		for _, line := range strings.Split(info, "\r\n") {
+			if v, ok := strings.CutPrefix(line, "redis_version:"); ok {
+				return v, true
+			}
+		}
+	}
+	return "", true
+}
+
+// probeMongo sends a hello command as an OP_MSG and checks the reply
+// header.
+func probeMongo(conn net.Conn, r *bufio.Reader) (string, bool) {
+	const opMsg = 2013
+	// BSON {hello: 1, $db: "admin"}
+	doc := []byte{0, 0, 0, 0}
+	doc = append(doc, 0x10)
+	doc = append(doc, "hello\x00"...)
+	doc = binary.LittleEndian.AppendUint32(doc, 1)
+	doc = append(doc, 0x02)
+	doc = append(doc, "$db\x00"...)
+	doc = binary.LittleEndian.AppendUint32(doc, 6)
+	doc = append(doc, "admin\x00"...)
+	doc = append(doc, 0)
+	binary.LittleEndian.PutUint32(doc, uint32(len(doc)))
+
+	msg := make([]byte, 16, 16+5+len(doc))
+	binary.LittleEndian.PutUint32(msg[4:], 1) // request id
+	binary.LittleEndian.PutUint32(msg[12:], opMsg)
+	msg = append(msg, 0, 0, 0, 0, 0) // flags, body section
+	msg = append(msg, doc...)
+	binary.LittleEndian.PutUint32(msg, uint32(len(msg)))
+	if _, err := conn.Write(msg); err != nil {
+		return "", false
+	}
+
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return "", false
+	}
+	return "", binary.LittleEndian.Uint32(hdr[8:]) == 1 && binary.LittleEndian.Uint32(hdr[12:]) == opMsg
+}
@@ -1,25 +1,37 @@
 package db
 
 import (
+	"errors"
 	"io"
 	"log"
 	"net"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/devlink-sh/devlink/internal"
 	"github.com/openziti/zrok/environment"
+	"github.com/openziti/zrok/environment/env_core"
 	"github.com/openziti/zrok/sdk/golang/sdk"
 	"github.com/spf13/cobra"
 )
 
 var dbGetCmd = &cobra.Command{
-	Use:   "get <token> <port>",
+	Use:   "get <token> <port|unix:///path>",
 	Short: "Connect to a shared database",
-	Args:  cobra.ExactArgs(2),
+	Long: `Open a local tunnel to a shared database and print its connection string.
+With --launch, psql, mysql, redis-cli or mongosh is started on the tunnel and
+db get exits with it. Give unix:///path instead of a port to expose the tunnel
+as a Unix socket, e.g. unix:///tmp/.s.PGSQL.5432 for psql.`,
+	Args: cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
 		token := args[0]
-		port := args[1]
+		launch, _ := cmd.Flags().GetBool("launch")
+		local, err := internal.ParseEndpoint(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
 
 		root, err := environment.LoadRoot()
 		if err != nil {
@@ -37,44 +49,116 @@ var dbGetCmd = &cobra.Command{
 		}()
 
 		// Listen locally
-		listener, err := net.Listen("tcp", "127.0.0.1:"+port)
+		listener, err := local.Listen()
 		if err != nil {
 			log.Fatal(err)
 		}
 		defer listener.Close()
-		log.Printf("DB tunnel ready at 127.0.0.1:%s", port)
+		log.Printf("DB tunnel ready at %s", local)
+
+		meta, err := fetchMeta(token, root)
+		if err != nil {
+			log.Printf("error fetching connection details: %v", err)
+		}
+		password, err := meta.openPassword()
+		if err == internal.ErrNotRecipient {
+			log.Println("The share's password was not shared with you.")
+		} else if err != nil {
+			log.Printf("error opening the share's password: %v", err)
+		}
+		if dsn := meta.dsn(local, password); dsn != "" {
+			log.Printf("Connect with:\n\n  %s\n", dsn)
+		}
 
-		// Handle SIGINT/SIGTERM cleanly
+		// Handle SIGINT/SIGTERM cleanly. While a launched client runs,
+		// Ctrl-C is left to it, e.g. to cancel a query.
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 		go func() {
-			<-c
-			log.Println("Shutting down db get...")
-			_ = listener.Close()
-			os.Exit(0)
+			for sig := range c {
+				if launch && sig == os.Interrupt {
+					continue
+				}
+				log.Println("Shutting down db get...")
+				_ = listener.Close()
+				os.Exit(0)
+			}
 		}()
 
-		for {
-			client, err := listener.Accept()
+		if launch {
+			client, err := meta.clientCommand(local, password)
 			if err != nil {
-				log.Printf("error accepting local client: %v", err)
-				continue
+				log.Fatal(err)
 			}
-
-			go func(c net.Conn) {
-				remote, err := sdk.NewDialer(token, root)
-				if err != nil {
-					log.Printf("error dialing zrok: %v", err)
-					c.Close()
-					return
-				}
-				log.Printf("Client connected, tunneling traffic...")
-				Pipe(c, remote)
-			}(client)
+			go acceptDB(listener, token, root)
+			if err := client.Run(); err != nil {
+				log.Printf("%s: %v", client.Args[0], err)
+			}
+			return
 		}
+		acceptDB(listener, token, root)
 	},
 }
 
+// fetchMeta asks the share for its connection details.
+func fetchMeta(token string, root env_core.Root) (dbMeta, error) {
+	conn, err := sdk.NewDialer(token, root)
+	if err != nil {
+		return dbMeta{}, err
+	}
+	defer conn.Close()
+	return requestMeta(conn)
+}
+
+// requestMeta sends the meta preamble on conn and reads the reply. An older
+// share takes the preamble for database traffic and may never answer, so it
+// gives up after preambleWait.
+func requestMeta(conn net.Conn) (dbMeta, error) {
+	_ = conn.SetDeadline(time.Now().Add(preambleWait))
+	meta, err := func() (dbMeta, error) {
+		if _, err := io.WriteString(conn, preambleMeta); err != nil {
+			return dbMeta{}, err
+		}
+		return readMeta(conn)
+	}()
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return dbMeta{}, errors.New("the share sent none, it may run an older devlink")
+	}
+	return meta, err
+}
+
+// acceptDB tunnels local clients to the share until the listener closes.
+func acceptDB(listener net.Listener, token string, root env_core.Root) {
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("error accepting local client: %v", err)
+			continue
+		}
+
+		go func(c net.Conn) {
+			remote, err := sdk.NewDialer(token, root)
+			if err != nil {
+				log.Printf("error dialing zrok: %v", err)
+				c.Close()
+				return
+			}
+			if _, err := io.WriteString(remote, preambleConn); err != nil {
+				log.Printf("error opening tunnel: %v", err)
+				c.Close()
+				remote.Close()
+				return
+			}
+			log.Printf("Client connected, tunneling traffic...")
+			Pipe(c, remote)
+		}(client)
+	}
+}
+
 func Pipe(a, b net.Conn) {
 	defer a.Close()
 	defer b.Close()
@@ -92,3 +176,7 @@ func Pipe(a, b net.Conn) {
 
 	<-done // wait for one side to finish
 }
+
+func init() {
+	dbGetCmd.Flags().Bool("launch", false, "start the database's command line client on the tunnel")
+}
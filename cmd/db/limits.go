@@ -0,0 +1,89 @@
+package db
+
+import (
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connLimits enforces --max-conns and keeps the count of active
+// connections of a share.
+type connLimits struct {
+	max int // 0 for no limit
+
+	mu     sync.Mutex
+	active int
+}
+
+// acquire reserves a connection slot and returns the new count, or false
+// if max connections are already active.
+func (l *connLimits) acquire() (int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.active >= l.max {
+		return l.active, false
+	}
+	l.active++
+	return l.active, true
+}
+
+// release frees a slot and returns the new count.
+func (l *connLimits) release() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	return l.active
+}
+
+// activityConn records when data was last read from either side of a
+// proxied connection.
+type activityConn struct {
+	net.Conn
+	last *atomic.Int64 // unix nanoseconds
+}
+
+func (c activityConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.last.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// watchIdle closes both connections once neither side has sent anything
+// for idle. It returns the connections to proxy and a function to stop
+// watching; with idle 0 the connections are returned unchanged.
+func watchIdle(client, server net.Conn, idle time.Duration) (net.Conn, net.Conn, func()) {
+	if idle <= 0 {
+		return client, server, func() {}
+	}
+	last := &atomic.Int64{}
+	last.Store(time.Now().UnixNano())
+	done := make(chan struct{})
+
+	go func() {
+		tick := idle / 4
+		if tick < 100*time.Millisecond {
+			tick = 100 * time.Millisecond
+		}
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if now.Sub(time.Unix(0, last.Load())) >= idle {
+					log.Printf("[%s] closing connection idle for %s", client.RemoteAddr(), idle)
+					client.Close()
+					server.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	return activityConn{client, last}, activityConn{server, last}, func() { close(done) }
+}
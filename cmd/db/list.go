@@ -0,0 +1,42 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var dbListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Find databases running locally",
+	Long: `Probe the default PostgreSQL, MySQL, Redis and MongoDB ports and the ports
+published by running docker containers, identifying each database by its
+handshake. Share one with 'devlink db share --auto <name>'.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dbs := detectDatabases()
+		if len(dbs) == 0 {
+			log.Println("No local databases found.")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tENGINE\tVERSION\tPORT\tCONTAINER")
+		for _, d := range dbs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", d.Name, d.Engine, orDash(d.Version), d.Port, orDash(d.Container))
+		}
+		_ = w.Flush()
+
+		fmt.Printf("\nShare one with:\n  devlink db share --auto %s\n", dbs[0].Name)
+	},
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,417 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Masking strategies for --mask.
+const (
+	maskHash   = "hash"   // keyed hash, equal values stay equal within a share
+	maskRedact = "redact" // fixed placeholder
+	maskNull   = "null"   // NULL
+)
+
+// maskRedacted replaces redacted values.
+const maskRedacted = "REDACTED"
+
+// pgTextTypes are the type OIDs whose values may be replaced by a hash or
+// the placeholder; other columns are masked with NULL so clients can still
+// decode the row.
+var pgTextTypes = map[uint32]bool{18: true, 19: true, 25: true, 705: true, 1042: true, 1043: true}
+
+// maskRule masks one column, in any table if table is "*".
+type maskRule struct {
+	table    string // lower case
+	column   string // lower case
+	strategy string
+}
+
+// masker rewrites result columns covered by the --mask rules.
+//
+// Each connection first looks up the masked columns in the server's
+// catalog, so result columns are matched by the table OID and column
+// number of their RowDescription whatever they are called. Everything
+// else that may carry masked data is masked as a whole: columns of views
+// over masked tables or of tables created since the lookup, and computed
+// columns (row_to_json(u), u::text) of queries that may read a masked
+// table.
+type masker struct {
+	rules []maskRule
+	key   []byte
+}
+
+// newMasker parses --mask rules and the rules of --mask-file, one per line
+// with # comments. It returns nil if there are no rules.
+func newMasker(specs []string, file string) (*masker, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := scanner.Text()
+			if i := strings.IndexByte(line, '#'); i >= 0 {
+				line = line[:i]
+			}
+			if line = strings.TrimSpace(line); line != "" {
+				specs = append(specs, line)
+			}
+		}
+	}
+	if len(specs) == 0 {
+		return nil, nil
+	}
+
+	m := &masker{key: make([]byte, 32)}
+	if _, err := rand.Read(m.key); err != nil {
+		return nil, err
+	}
+	for _, spec := range specs {
+		rule, err := parseMaskRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		m.rules = append(m.rules, rule)
+	}
+	return m, nil
+}
+
+// parseMaskRule parses table.column=strategy; a schema prefix is ignored.
+func parseMaskRule(spec string) (maskRule, error) {
+	target, strategy, ok := strings.Cut(spec, "=")
+	if !ok {
+		return maskRule{}, fmt.Errorf("invalid mask rule %q, expected table.column=strategy", spec)
+	}
+	strategy = strings.ToLower(strings.TrimSpace(strategy))
+	switch strategy {
+	case maskHash, maskRedact, maskNull:
+	default:
+		return maskRule{}, fmt.Errorf("unknown mask strategy %q in %q (supported: hash, redact, null)", strategy, spec)
+	}
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(target)), ".")
+	if len(parts) < 2 || parts[len(parts)-2] == "" || parts[len(parts)-1] == "" {
+		return maskRule{}, fmt.Errorf("invalid mask rule %q, expected table.column=strategy", spec)
+	}
+	return maskRule{table: parts[len(parts)-2], column: parts[len(parts)-1], strategy: strategy}, nil
+}
+
+// strategy returns the strategy masking column of table, if any. A rule
+// for the table wins over a "*" rule.
+func (m *masker) strategy(table, column string) string {
+	strategy := ""
+	for _, r := range m.rules {
+		if r.column != column {
+			continue
+		}
+		if r.table == table {
+			return r.strategy
+		}
+		if r.table == "*" && strategy == "" {
+			strategy = r.strategy
+		}
+	}
+	return strategy
+}
+
+// lookupQuery lists, from the catalog, the masked table columns ('c'),
+// the views depending on masked tables ('v'), the functions that are not
+// built in ('f') and the highest relation OID ('m').
+func (m *masker) lookupQuery() string {
+	var rules []string
+	for _, r := range m.rules {
+		rules = append(rules, "("+pgQuote(r.table)+", "+pgQuote(r.column)+")")
+	}
+	return `WITH RECURSIVE rules(tab, col) AS (VALUES ` + strings.Join(rules, ", ") + `),
+masked AS (
+	SELECT c.oid, c.relname, a.attnum, r.col
+	FROM pg_catalog.pg_class c
+	JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+	JOIN rules r ON (r.tab = '*' OR pg_catalog.lower(c.relname) = r.tab) AND pg_catalog.lower(a.attname) = r.col
+),
+deps(oid) AS (
+	SELECT oid FROM masked
+	UNION
+	SELECT rw.ev_class FROM deps
+	JOIN pg_catalog.pg_depend d ON d.refclassid = 'pg_catalog.pg_class'::pg_catalog.regclass AND d.refobjid = deps.oid
+		AND d.classid = 'pg_catalog.pg_rewrite'::pg_catalog.regclass
+	JOIN pg_catalog.pg_rewrite rw ON rw.oid = d.objid AND rw.ev_class <> deps.oid
+)
+SELECT 'c', oid::pg_catalog.int8, attnum::pg_catalog.int8, relname::pg_catalog.text, col FROM masked
+UNION ALL
+SELECT 'v', c.oid::pg_catalog.int8, 0, c.relname::pg_catalog.text, '' FROM deps
+	JOIN pg_catalog.pg_class c ON c.oid = deps.oid WHERE c.relkind IN ('v', 'm')
+UNION ALL
+SELECT 'f', 0, 0, p.proname::pg_catalog.text, '' FROM pg_catalog.pg_proc p
+	JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace WHERE n.nspname NOT IN ('pg_catalog', 'information_schema')
+UNION ALL
+SELECT 'm', pg_catalog.max(oid)::pg_catalog.int8, 0, '', '' FROM pg_catalog.pg_class`
+}
+
+// pgQuote quotes s as a string literal, whatever standard_conforming_strings.
+func pgQuote(s string) string {
+	return "E'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// pgAttr is a table column as identified in a RowDescription.
+type pgAttr struct {
+	table  uint32
+	attnum int16
+}
+
+// maskCatalog is what the server's catalog says about the masked tables,
+// looked up once per connection.
+type maskCatalog struct {
+	columns   map[pgAttr]string // masked table columns -> strategy
+	relations map[uint32]bool   // views over masked tables, masked entirely
+	names     map[string]bool   // upper-cased names of masked tables, such views and functions
+	maxOID    uint32            // relations created later are masked entirely
+}
+
+// newMaskCatalog returns a catalog knowing only the names of the rules.
+func (m *masker) newMaskCatalog() *maskCatalog {
+	c := &maskCatalog{columns: map[pgAttr]string{}, relations: map[uint32]bool{}, names: map[string]bool{}}
+	for _, r := range m.rules {
+		if r.table != "*" {
+			c.names[strings.ToUpper(r.table)] = true
+		}
+	}
+	return c
+}
+
+// add records a row of the lookup query.
+func (c *maskCatalog) add(m *masker, row [][]byte) error {
+	if len(row) != 5 {
+		return fmt.Errorf("unexpected lookup row of %d columns", len(row))
+	}
+	oid, err := strconv.ParseUint(string(row[1]), 10, 32)
+	if err != nil {
+		return err
+	}
+	attnum, err := strconv.ParseInt(string(row[2]), 10, 16)
+	if err != nil {
+		return err
+	}
+	name := string(row[3])
+	switch string(row[0]) {
+	case "c":
+		c.columns[pgAttr{uint32(oid), int16(attnum)}] = m.strategy(strings.ToLower(name), string(row[4]))
+		c.names[strings.ToUpper(name)] = true
+	case "v":
+		c.relations[uint32(oid)] = true
+		c.names[strings.ToUpper(name)] = true
+	case "f":
+		c.names[strings.ToUpper(name)] = true
+	case "m":
+		c.maxOID = uint32(oid)
+	}
+	return nil
+}
+
+// reads reports whether query may compute results from masked data: it
+// names a masked table, a view over one or a function that is not built
+// in, or runs a statement prepared or a cursor declared earlier.
+func (c *maskCatalog) reads(query string) bool {
+	for _, stmt := range pgSQL.split(query) {
+		for _, w := range stmt {
+			if c.names[w.word] || w.word == "EXECUTE" || w.word == "FETCH" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// maskRefused are refused on masked shares: they run SQL given as a
+// string, read column statistics or change how queries are lexed.
+var maskRefused = words("QUERY_TO_XML", "QUERY_TO_XMLSCHEMA", "QUERY_TO_XML_AND_XMLSCHEMA",
+	"CURSOR_TO_XML", "CURSOR_TO_XMLSCHEMA", "TABLE_TO_XML", "TABLE_TO_XMLSCHEMA",
+	"TABLE_TO_XML_AND_XMLSCHEMA", "SCHEMA_TO_XML", "SCHEMA_TO_XMLSCHEMA",
+	"SCHEMA_TO_XML_AND_XMLSCHEMA", "DATABASE_TO_XML", "DATABASE_TO_XMLSCHEMA",
+	"DATABASE_TO_XML_AND_XMLSCHEMA", "TS_STAT", "PG_STATS", "PG_STATS_EXT",
+	"PG_STATS_EXT_EXPRS", "PG_STATISTIC", "PG_STATISTIC_EXT_DATA", "UESCAPE",
+	"STANDARD_CONFORMING_STRINGS")
+
+// check refuses queries whose results would bypass masking.
+func (m *masker) check(cat *maskCatalog, query string) error {
+	reads := cat.reads(query)
+	for _, stmt := range pgSQL.split(query) {
+		switch {
+		case stmt[0].word == "DO":
+			return errors.New("DO is not allowed on a masked share")
+		case stmt[0].word == "COPY" && reads:
+			return errors.New("COPY of masked tables is not allowed on this share")
+		}
+		for _, w := range stmt {
+			if maskRefused[w.word] {
+				return fmt.Errorf("%s is not allowed on a masked share", w.word)
+			}
+		}
+	}
+	return nil
+}
+
+// pgColumn is a result column from a RowDescription.
+type pgColumn struct {
+	name     string
+	tableOID uint32 // 0 if not a table column
+	attnum   int16
+	typeOID  uint32
+}
+
+func parseRowDescription(body []byte) ([]pgColumn, error) {
+	if len(body) < 2 {
+		return nil, errors.New("short RowDescription")
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	body = body[2:]
+	cols := make([]pgColumn, 0, n)
+	for i := 0; i < n; i++ {
+		var name string
+		name, body = cstring(body)
+		if len(body) < 18 {
+			return nil, errors.New("short RowDescription")
+		}
+		cols = append(cols, pgColumn{
+			name:     name,
+			tableOID: binary.BigEndian.Uint32(body),
+			attnum:   int16(binary.BigEndian.Uint16(body[4:])),
+			typeOID:  binary.BigEndian.Uint32(body[6:]),
+		})
+		body = body[18:]
+	}
+	return cols, nil
+}
+
+// parseDataRow splits a DataRow body into its values, nil for NULL.
+func parseDataRow(body []byte) ([][]byte, error) {
+	if len(body) < 2 {
+		return nil, errors.New("short DataRow")
+	}
+	row := make([][]byte, binary.BigEndian.Uint16(body))
+	rest := body[2:]
+	for i := range row {
+		if len(rest) < 4 {
+			return nil, errors.New("short DataRow")
+		}
+		size := int32(binary.BigEndian.Uint32(rest))
+		rest = rest[4:]
+		if size < 0 {
+			continue
+		}
+		if int(size) > len(rest) {
+			return nil, errors.New("short DataRow")
+		}
+		row[i], rest = rest[:size], rest[size:]
+	}
+	return row, nil
+}
+
+// plan returns the strategy for each column of a result of query, or nil
+// if nothing needs masking.
+func (m *masker) plan(cat *maskCatalog, query string, cols []pgColumn) []string {
+	reads := cat.reads(query)
+	strategies := make([]string, len(cols))
+	masked := false
+	for j, c := range cols {
+		switch {
+		case c.tableOID == 0:
+			if reads {
+				strategies[j] = maskRedact
+			}
+		case c.tableOID > cat.maxOID || cat.relations[c.tableOID]:
+			strategies[j] = maskRedact
+		default:
+			strategies[j] = cat.columns[pgAttr{c.tableOID, c.attnum}]
+		}
+		if strategies[j] == "" {
+			continue
+		}
+		masked = true
+		if !pgTextTypes[c.typeOID] {
+			strategies[j] = maskNull
+		}
+	}
+	if !masked {
+		return nil
+	}
+	return strategies
+}
+
+// maskError hides the text of errors that may quote values, such as
+// invalid input or unique violations, keeping their SQLSTATE.
+func maskError(body []byte) []byte {
+	var code string
+	for rest := body; len(rest) > 0 && rest[0] != 0; {
+		typ := rest[0]
+		var val string
+		val, rest = cstring(rest[1:])
+		if typ == 'C' {
+			code = val
+		}
+	}
+	if !strings.HasPrefix(code, "22") && !strings.HasPrefix(code, "23") && !strings.HasPrefix(code, "P0") {
+		return body
+	}
+	return pgError(code, "error text hidden on a masked share (SQLSTATE "+code+")")
+}
+
+// maskDataRow rewrites a DataRow body following plan.
+func (m *masker) maskDataRow(body []byte, plan []string) ([]byte, error) {
+	if len(body) < 2 {
+		return nil, errors.New("short DataRow")
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	out := make([]byte, 2, len(body))
+	copy(out, body)
+	rest := body[2:]
+	for i := 0; i < n; i++ {
+		if len(rest) < 4 {
+			return nil, errors.New("short DataRow")
+		}
+		size := int32(binary.BigEndian.Uint32(rest))
+		rest = rest[4:]
+		var val []byte
+		if size >= 0 {
+			if int(size) > len(rest) {
+				return nil, errors.New("short DataRow")
+			}
+			val, rest = rest[:size], rest[size:]
+		}
+		if i < len(plan) && plan[i] != "" && size >= 0 {
+			val = m.mask(plan[i], val)
+		}
+		if val == nil {
+			out = binary.BigEndian.AppendUint32(out, 0xffffffff)
+			continue
+		}
+		out = binary.BigEndian.AppendUint32(out, uint32(len(val)))
+		out = append(out, val...)
+	}
+	return out, nil
+}
+
+// mask applies a strategy to a non-NULL value; nil means NULL.
+func (m *masker) mask(strategy string, val []byte) []byte {
+	switch strategy {
+	case maskHash:
+		h := hmac.New(sha256.New, m.key)
+		h.Write(val)
+		return []byte(hex.EncodeToString(h.Sum(nil))[:16])
+	case maskRedact:
+		return []byte(maskRedacted)
+	default:
+		return nil
+	}
+}
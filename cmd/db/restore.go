@@ -0,0 +1,265 @@
+package db
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/devlink-sh/devlink/internal"
+	"github.com/openziti/zrok/environment"
+	"github.com/openziti/zrok/sdk/golang/sdk"
+	"github.com/spf13/cobra"
+)
+
+// restoreCommand builds the pg_restore or mysql invocation reading the dump
+// on stdin.
+func restoreCommand(dbType string, port int, user, database string) (*exec.Cmd, error) {
+	switch dbType {
+	case "postgres":
+		args := []string{"-h", "127.0.0.1", "-p", strconv.Itoa(port), "--no-owner", "--no-privileges", "--exit-on-error"}
+		if user != "" {
+			args = append(args, "-U", user)
+		}
+		// Without -d pg_restore prints the archive as SQL instead of loading
+		// it; a connection string without dbname is the user's database
+		if database == "" {
+			database = "host=127.0.0.1"
+		}
+		return exec.Command("pg_restore", append(args, "-d", database)...), nil
+	case "mysql":
+		// --binary-mode turns off the client's own commands such as \! and
+		// system for piped input, but for DELIMITER
+		args := []string{"-h", "127.0.0.1", "-P", strconv.Itoa(port), "--protocol=TCP", "--binary-mode"}
+		if user != "" {
+			args = append(args, "-u", user)
+		}
+		if database != "" {
+			args = append(args, database)
+		}
+		return exec.Command("mysql", args...), nil
+	default:
+		return nil, fmt.Errorf("unsupported database type %q", dbType)
+	}
+}
+
+// pgArchiveMagic starts a pg_dump custom format archive.
+const pgArchiveMagic = "PGDMP"
+
+// mariadbSandbox starts dumps of recent MariaDB versions. It turns on the
+// sandbox mode of their client, which refuses client commands, and is a
+// comment for other clients.
+const mariadbSandbox = `/*M!999999\- enable the sandbox mode */`
+
+// checkMysqlDump refuses a dump the mysql client would not pass on to the
+// server as it is: a statement with a backslash outside literals, such as
+// \! which runs a shell, a line starting with a client command such as
+// system or source, or a statement ending inside a literal. Statements are
+// lexed as mysqldump writes them, with backslash escapes, and the
+// DELIMITER ;; it puts around routines and triggers is followed.
+func checkMysqlDump(r io.Reader) error {
+	br := bufio.NewReader(r)
+	delimiter := ";"
+	var stmt strings.Builder
+	start := 1 // line the statement starts on
+	check := func(text string) error {
+		err := mysqlSQL.clientSafe(strings.TrimRight(text, " \t\r\n") + "\n;")
+		if err == nil {
+			err = mysqlClientCommand(text)
+		}
+		if err != nil {
+			return fmt.Errorf("statement on line %d: %v", start, err)
+		}
+		return nil
+	}
+	for n := 1; ; n++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line == "" {
+			break
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == mariadbSandbox && commentsOnly(stmt.String()) {
+			continue
+		}
+		if f := strings.Fields(trimmed); len(f) > 0 && strings.EqualFold(f[0], "DELIMITER") {
+			// only between statements, where the client takes it as a command
+			if err := check(stmt.String()); err != nil {
+				return err
+			}
+			if !commentsOnly(stmt.String()) {
+				return fmt.Errorf("DELIMITER on line %d inside a statement", n)
+			}
+			if len(f) != 2 || f[1] != ";" && f[1] != ";;" {
+				return fmt.Errorf("unexpected %q on line %d", trimmed, n)
+			}
+			delimiter = f[1]
+			stmt.Reset()
+			start = n + 1
+			continue
+		}
+		stmt.WriteString(line)
+		if strings.HasSuffix(trimmed, delimiter) {
+			text := strings.TrimRight(stmt.String(), " \t\r\n")
+			if err := check(strings.TrimSuffix(text, delimiter)); err != nil {
+				return err
+			}
+			stmt.Reset()
+			start = n + 1
+		}
+	}
+	// the client runs what is left at the end too
+	return check(stmt.String())
+}
+
+// commentsOnly reports whether text has nothing but blank and comment lines.
+func commentsOnly(text string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") && line != "--" && !strings.HasPrefix(line, "-- ") {
+			return false
+		}
+	}
+	return true
+}
+
+var dbRestoreCmd = &cobra.Command{
+	Use:   "restore <token>",
+	Short: "Load a shared database snapshot",
+	Long: `Receive a snapshot shared with 'devlink db snapshot', verify it and load it
+into a local database with pg_restore or mysql.
+Example: devlink db restore <token> --port 5433 --database app_copy`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		token := args[0]
+		port, _ := cmd.Flags().GetInt("port")
+		user, _ := cmd.Flags().GetString("user")
+		database, _ := cmd.Flags().GetString("database")
+		output, _ := cmd.Flags().GetString("output")
+
+		root, err := environment.LoadRoot()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		acc, err := sdk.CreateAccess(root, &sdk.AccessRequest{ShareToken: token})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		conn, err := sdk.NewDialer(token, root)
+		if err != nil {
+			_ = sdk.DeleteAccess(root, acc)
+			log.Fatal(err)
+		}
+		err = restoreSnapshot(conn, token, port, user, database, output)
+		_ = conn.Close()
+		if derr := sdk.DeleteAccess(root, acc); derr != nil {
+			log.Printf("error deleting access: %v", derr)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// restoreSnapshot receives a snapshot frame from r and loads it, or writes
+// the dump to output if set. Dumps that could run something on this machine
+// are refused: postgres snapshots must be pg_restore archives rather than
+// psql scripts, and mysql dumps are checked with checkMysqlDump.
+func restoreSnapshot(r io.Reader, token string, port int, user, database, output string) error {
+	// Receive the whole snapshot first so a truncated dump is never loaded
+	tmp, err := os.CreateTemp("", "devlink-restore-*.gz")
+	if err != nil {
+		return err
+	}
+	_ = tmp.Close()
+	defer os.Remove(tmp.Name())
+	frame, n, err := internal.SaveFrame(r, tmp.Name())
+	if err != nil {
+		return fmt.Errorf("failed to receive snapshot: %w", err)
+	}
+	if frame.Header.Kind != "db-snapshot" {
+		return fmt.Errorf("token %s does not share a database snapshot (got %q)", token, frame.Header.Kind)
+	}
+	dbType := frame.Header.Meta["type"]
+	if database == "" {
+		database = frame.Header.Meta["database"]
+	}
+	log.Printf("Received %s snapshot %s (%d bytes, sha256 %s)", dbType, frame.Header.Name, n, frame.Sum())
+
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+	dump := bufio.NewReader(gz)
+	switch dbType {
+	case "postgres":
+		if magic, _ := dump.Peek(len(pgArchiveMagic)); string(magic) != pgArchiveMagic {
+			return fmt.Errorf("snapshot %s is not a pg_dump archive, share it again with this version of devlink", frame.Header.Name)
+		}
+	case "mysql":
+		if err := checkMysqlDump(dump); err != nil {
+			return fmt.Errorf("refusing snapshot %s: %w", frame.Header.Name, err)
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := gz.Reset(f); err != nil {
+			return fmt.Errorf("invalid snapshot: %w", err)
+		}
+		dump.Reset(gz)
+	}
+
+	if output != "" {
+		out, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, dump)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("error writing %s: %w", output, err)
+		}
+		log.Printf("Snapshot written to %s", output)
+		return nil
+	}
+
+	if port == 0 {
+		port = defaultPorts[dbType]
+	}
+	load, err := restoreCommand(dbType, port, user, database)
+	if err != nil {
+		return err
+	}
+	load.Stdin = dump
+	load.Stdout = os.Stdout
+	load.Stderr = os.Stderr
+	log.Printf("Loading snapshot into %s on 127.0.0.1:%d...", dbType, port)
+	if err := load.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w", load.Args[0], err)
+	}
+	log.Println("Snapshot restored successfully.")
+	return nil
+}
+
+func init() {
+	dbRestoreCmd.Flags().Int("port", 0, "local database port (default: 5432 for postgres, 3306 for mysql)")
+	dbRestoreCmd.Flags().String("user", "", "local database user")
+	dbRestoreCmd.Flags().String("database", "", "database to load into (default: the dumped database)")
+	dbRestoreCmd.Flags().StringP("output", "o", "", "write the dump to this file instead of loading it (a pg_restore archive for postgres)")
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+
+	"github.com/devlink-sh/devlink/internal"
+)
+
+const mysqlDumpExcerpt = `/*M!999999\- enable the sandbox mode */
+-- MySQL dump 10.13  Distrib 8.0.36, for Linux (x86_64)
+--
+-- Host: 127.0.0.1    Database: app
+/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;
+/*!40101 SET NAMES utf8mb4 */;
+DROP TABLE IF EXISTS ` + "`users`" + `;
+CREATE TABLE ` + "`users`" + ` (
+  ` + "`id`" + ` int NOT NULL,
+  ` + "`name`" + ` varchar(64) DEFAULT NULL
+) ENGINE=InnoDB;
+INSERT INTO ` + "`users`" + ` VALUES (1,'O\'Brien'),(2,'back\\slash;\n\\! id');
+/*!50003 SET sql_mode = 'ONLY_FULL_GROUP_BY' */ ;
+DELIMITER ;;
+/*!50003 CREATE*/ /*!50003 TRIGGER ` + "`users_bi`" + ` BEFORE INSERT ON ` + "`users`" + ` FOR EACH ROW BEGIN
+  SET NEW.name = TRIM(NEW.name);
+END */;;
+DELIMITER ;
+-- Dump completed on 2026-10-18 12:00:00
+`
+
+func TestCheckMysqlDump(t *testing.T) {
+	if err := checkMysqlDump(strings.NewReader(mysqlDumpExcerpt)); err != nil {
+		t.Errorf("checkMysqlDump(mysqldump output): %v", err)
+	}
+	for _, dump := range []string{
+		"\\! id\n",
+		"SELECT 1;\n\\! id\n",
+		"INSERT INTO t VALUES ('a');\\! id\n",
+		"SELECT 1 /* \\! id */;\n",
+		"system id\n",
+		"--\n-- comment\nsource /etc/passwd\n",
+		"SELECT 'a;\n\\! id\n';\n",
+		"DELIMITER //\n",
+		"SELECT 1\nDELIMITER ;;\n",
+		"DELIMITER ;;\nCREATE PROCEDURE p() BEGIN SELECT 1; END ;;\n\\! id\n",
+	} {
+		if err := checkMysqlDump(strings.NewReader(dump)); err == nil {
+			t.Errorf("checkMysqlDump(%q) accepted", dump)
+		}
+	}
+}
+
+func TestRestoreSnapshotRefusesClientCommands(t *testing.T) {
+	for _, tt := range []struct {
+		dbType, want string
+	}{
+		{"postgres", "not a pg_dump archive"},
+		{"mysql", "refusing snapshot"},
+	} {
+		var dump bytes.Buffer
+		gz := gzip.NewWriter(&dump)
+		gz.Write([]byte("SELECT 1;\n\\! id\n"))
+		gz.Close()
+		var frame bytes.Buffer
+		header := internal.FrameHeader{Kind: "db-snapshot", Name: "app.gz", Size: int64(dump.Len()), Meta: map[string]string{"type": tt.dbType}}
+		if _, err := internal.WriteFrame(&frame, header, &dump); err != nil {
+			t.Fatal(err)
+		}
+		err := restoreSnapshot(&frame, "token", 1, "", "app", "")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("restoreSnapshot(%s dump with \\! id) = %v, want %q", tt.dbType, err, tt.want)
+		}
+	}
+}
@@ -1,24 +1,137 @@
 package db
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
+	"github.com/devlink-sh/devlink/internal"
 	"github.com/openziti/zrok/environment"
 	"github.com/openziti/zrok/sdk/golang/sdk"
 	"github.com/spf13/cobra"
 )
 
 var dbShareCmd = &cobra.Command{
-	Use:   "share <port>",
+	Use:   "share [port|unix:///path]",
 	Short: "Share a local database",
-	Long:  `Securely share a local database over zrok. Example: devlink db share 5432`,
-	Args:  cobra.ExactArgs(1),
+	Long: `Securely share a local database over zrok. Example: devlink db share 5432
+The database may also be reached over a Unix socket, e.g.
+  devlink db share unix:///var/run/postgresql/.s.PGSQL.5432 --type postgres
+
+With --type postgres or mysql, connections are proxied at the protocol level: every
+query is logged with client, duration and row count, and --read-only rejects
+statements that modify data or schema. With --type redis, commands are logged
+and --allow/--deny control which ones clients may run, e.g.
+  devlink db share 6379 --type redis --deny FLUSHALL,FLUSHDB,CONFIG
+
+With --type postgres, --mask rewrites result columns before they leave this
+machine, using hash, redact or null, e.g.
+  devlink db share 5432 --type postgres --mask users.email=hash,users.phone=redact
+Rules can also be listed one per line in a --mask-file.
+
+--max-conns caps the connections opened to the local database, --idle-timeout
+closes connections without traffic and --duration ends the share.
+
+With --auto, the port and --type come from a database found by 'devlink db list'.
+
+--database and --user are passed on so 'devlink db get' can print a connection
+string. A password, read from the variable named by --password-env, is only
+passed on sealed for the contacts given with --to.
+
+--record appends every query to a JSON lines file, which 'devlink db replay'
+can run against another database.`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		port := args[0]
+		dbType, _ := cmd.Flags().GetString("type")
+		auto, _ := cmd.Flags().GetString("auto")
+		var port string
+		engine := ""
+		switch {
+		case auto != "" && len(args) > 0:
+			log.Fatal("give either a port or --auto")
+		case auto != "":
+			d, err := findDatabase(auto)
+			if err != nil {
+				log.Fatal(err)
+			}
+			port = strconv.Itoa(d.Port)
+			if !cmd.Flags().Changed("type") {
+				dbType = d.shareType()
+			}
+			engine = d.Engine
+			log.Printf("Sharing %s (%s) on port %s", d.Name, d.Engine, port)
+		case len(args) == 0:
+			log.Fatal("give the port of the database to share, or --auto <name> (see 'devlink db list')")
+		default:
+			port = args[0]
+		}
+		backend, err := internal.ParseEndpoint(port)
+		if err != nil {
+			log.Fatal(err)
+		}
+		readOnly, _ := cmd.Flags().GetBool("read-only")
+		allow, _ := cmd.Flags().GetStringSlice("allow")
+		deny, _ := cmd.Flags().GetStringSlice("deny")
+		maskRules, _ := cmd.Flags().GetStringSlice("mask")
+		maskFile, _ := cmd.Flags().GetString("mask-file")
+		maxConns, _ := cmd.Flags().GetInt("max-conns")
+		idleTimeout, _ := cmd.Flags().GetDuration("idle-timeout")
+		duration, _ := cmd.Flags().GetDuration("duration")
+
+		if engine == "" && dbType != "tcp" {
+			engine = dbType
+		}
+		meta := dbMeta{engine: engine}
+		meta.database, _ = cmd.Flags().GetString("database")
+		meta.user, _ = cmd.Flags().GetString("user")
+		if passwordEnv, _ := cmd.Flags().GetString("password-env"); passwordEnv != "" {
+			to, _ := cmd.Flags().GetStringSlice("to")
+			if len(to) == 0 {
+				log.Fatal("--password-env needs --to, the password is only shared sealed for known contacts")
+			}
+			password := os.Getenv(passwordEnv)
+			if password == "" {
+				log.Fatalf("%s is not set", passwordEnv)
+			}
+			recipients, err := internal.ResolveRecipients(to)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if meta.password, err = internal.Seal([]byte(password), recipients); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		mask, err := newMasker(maskRules, maskFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var record *recorder
+		if path, _ := cmd.Flags().GetString("record"); path != "" {
+			if dbType == "tcp" {
+				log.Fatal("--record needs a protocol aware --type")
+			}
+			if record, err = newRecorder(path, dbType); err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Recording queries to %s", path)
+		}
+		proxy, err := newProxy(dbType, proxyOptions{
+			readOnly: readOnly,
+			allow:    commandSet(allow),
+			deny:     commandSet(deny),
+			mask:     mask,
+			record:   record,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
 
 		root, err := environment.LoadRoot()
 		if err != nil {
@@ -54,27 +167,113 @@ var dbShareCmd = &cobra.Command{
 				log.Printf("error deleting share: %v", err)
 			}
 			_ = listener.Close()
+			if record != nil {
+				_ = record.Close()
+			}
 			os.Exit(0)
 		}()
 
+		if duration > 0 {
+			log.Printf("Share will end in %s", duration)
+			time.AfterFunc(duration, func() {
+				log.Printf("Share duration of %s reached", duration)
+				c <- os.Interrupt
+			})
+		}
+
+		limits := &connLimits{max: maxConns}
+
 		for {
 			conn, err := listener.Accept()
 			if err != nil {
+				// zrok listeners do not return net.ErrClosed once closed
+				if errors.Is(err, net.ErrClosed) || listener.IsClosed() {
+					return
+				}
 				log.Printf("error accepting zrok connection: %v", err)
 				continue
 			}
 
 			go func(remote net.Conn) {
-				local, err := net.Dial("tcp", "127.0.0.1:"+port)
+				preamble, conn, err := readPreamble(remote)
+				if err != nil {
+					log.Printf("error reading tunnel connection: %v", err)
+					remote.Close()
+					return
+				}
+				remote = conn
+				if preamble == preambleMeta {
+					if err := writeMeta(remote, meta); err != nil {
+						log.Printf("error sending connection details: %v", err)
+					}
+					remote.Close()
+					return
+				}
+
+				active, ok := limits.acquire()
+				if !ok {
+					log.Printf("Refusing DB connection: %d connections active (--max-conns)", active)
+					remote.Close()
+					return
+				}
+				defer func() {
+					log.Printf("DB connection closed (%d active)", limits.release())
+				}()
+
+				local, err := backend.Dial()
 				if err != nil {
 					log.Printf("error dialing local DB: %v", err)
 					remote.Close()
 					return
 				}
-				log.Printf("Forwarding DB connection -> localhost:%s", port)
-				Pipe(remote, local)
+				log.Printf("Forwarding DB connection -> %s (%d active)", backend, active)
+				client, server, stop := watchIdle(remote, local, idleTimeout)
+				defer stop()
+				if err := proxy.proxy(client, server); err != nil {
+					log.Printf("error proxying DB connection: %v", err)
+				}
 			}(conn)
 		}
 
 	},
 }
+
+func init() {
+	dbShareCmd.Flags().String("type", "tcp", "database protocol: tcp (raw forwarding), postgres, mysql or redis")
+	dbShareCmd.Flags().String("auto", "", "share the database with this name from 'devlink db list'")
+	dbShareCmd.Flags().Bool("read-only", false, "reject statements that modify data or schema (postgres, mysql)")
+	dbShareCmd.Flags().StringSlice("allow", nil, "only allow these commands, e.g. GET,SET,CONFIG|GET (redis)")
+	dbShareCmd.Flags().StringSlice("deny", nil, "refuse these commands, e.g. FLUSHALL,FLUSHDB,CONFIG; also refuses scripting (EVAL, FCALL, ...) unless listed in --allow (redis)")
+	dbShareCmd.Flags().StringSlice("mask", nil, "mask result columns, e.g. users.email=hash,users.phone=redact (postgres)")
+	dbShareCmd.Flags().String("mask-file", "", "file with one table.column=strategy mask rule per line (postgres)")
+	dbShareCmd.Flags().Int("max-conns", 0, "maximum concurrent connections to the database (0 for no limit)")
+	dbShareCmd.Flags().Duration("idle-timeout", 0, "close connections idle for this long, e.g. 10m (0 to keep them open)")
+	dbShareCmd.Flags().Duration("duration", 0, "end the share after this long, e.g. 2h (0 to share until interrupted)")
+	dbShareCmd.Flags().String("database", "", "database name to put in connection strings")
+	dbShareCmd.Flags().String("user", "", "user name to put in connection strings")
+	dbShareCmd.Flags().String("password-env", "", "environment variable holding the password to share, sealed for --to")
+	dbShareCmd.Flags().StringSlice("to", nil, "contacts who may read the password (see 'devlink keys')")
+	dbShareCmd.Flags().String("record", "", "append every query to this JSON lines file")
+}
+
+// findDatabase looks up a database from db list by name, or by engine if
+// only one runs.
+func findDatabase(name string) (detectedDB, error) {
+	dbs := detectDatabases()
+	var matches []detectedDB
+	for _, d := range dbs {
+		if d.Name == name {
+			return d, nil
+		}
+		if d.Engine == name {
+			matches = append(matches, d)
+		}
+	}
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+	if len(matches) > 1 {
+		return detectedDB{}, fmt.Errorf("several %s databases found, pick one by name from 'devlink db list'", name)
+	}
+	return detectedDB{}, fmt.Errorf("no local database named %q, see 'devlink db list'", name)
+}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"compress/gzip"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/devlink-sh/devlink/internal"
+	"github.com/openziti/zrok/environment"
+	"github.com/openziti/zrok/sdk/golang/sdk"
+	"github.com/spf13/cobra"
+)
+
+// dumpOptions selects what db snapshot dumps.
+type dumpOptions struct {
+	host       string
+	port       int
+	user       string
+	database   string
+	schemaOnly bool
+	tables     []string
+}
+
+// dumpCommand builds the pg_dump or mysqldump invocation writing the dump
+// to stdout: an uncompressed custom format archive for pg_restore, which
+// unlike a SQL script cannot carry psql commands, or SQL for mysql.
+// Credentials come from the usual environment (PGPASSWORD, ~/.pgpass,
+// MYSQL_PWD, ~/.my.cnf).
+func dumpCommand(dbType string, o dumpOptions) (*exec.Cmd, error) {
+	port := strconv.Itoa(o.port)
+	switch dbType {
+	case "postgres":
+		args := []string{"-h", o.host, "-p", port, "--format=custom", "--compress=0", "--no-owner", "--no-privileges"}
+		if o.user != "" {
+			args = append(args, "-U", o.user)
+		}
+		if o.schemaOnly {
+			args = append(args, "--schema-only")
+		}
+		for _, t := range o.tables {
+			args = append(args, "-t", t)
+		}
+		if o.database != "" {
+			args = append(args, o.database)
+		}
+		return exec.Command("pg_dump", args...), nil
+	case "mysql":
+		args := []string{"-h", o.host, "-P", port, "--protocol=TCP", "--single-transaction", "--routines", "--triggers"}
+		if o.user != "" {
+			args = append(args, "-u", o.user)
+		}
+		if o.schemaOnly {
+			args = append(args, "--no-data")
+		}
+		// A single database is dumped without CREATE DATABASE/USE so that
+		// db restore --database can load it under another name
+		switch {
+		case len(o.tables) > 0 && o.database == "":
+			return nil, fmt.Errorf("--tables needs --database for mysql")
+		case o.database != "":
+			args = append(append(args, o.database), o.tables...)
+		default:
+			args = append(args, "--all-databases")
+		}
+		return exec.Command("mysqldump", args...), nil
+	default:
+		return nil, fmt.Errorf("unsupported --type %q (supported: postgres, mysql)", dbType)
+	}
+}
+
+// dumpToFile runs the dump and stores it gzip-compressed in a temp file.
+func dumpToFile(dump *exec.Cmd) (string, error) {
+	f, err := os.CreateTemp("", "devlink-snapshot-*.gz")
+	if err != nil {
+		return "", err
+	}
+	gz := gzip.NewWriter(f)
+	dump.Stdout = gz
+	dump.Stderr = os.Stderr
+	err = dump.Run()
+	if cerr := gz.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("%s failed: %w", dump.Args[0], err)
+	}
+	return f.Name(), nil
+}
+
+var dbSnapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Share a copy of a local database",
+	Long: `Dump a local database with pg_dump or mysqldump and share the compressed
+dump, so teammates can load a copy with 'devlink db restore <token>'.
+Example: devlink db snapshot --type postgres --database app --tables users,orders`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dbType, _ := cmd.Flags().GetString("type")
+		opts := dumpOptions{}
+		opts.host, _ = cmd.Flags().GetString("host")
+		opts.port, _ = cmd.Flags().GetInt("port")
+		opts.user, _ = cmd.Flags().GetString("user")
+		opts.database, _ = cmd.Flags().GetString("database")
+		opts.schemaOnly, _ = cmd.Flags().GetBool("schema-only")
+		opts.tables, _ = cmd.Flags().GetStringSlice("tables")
+		if opts.port == 0 {
+			opts.port = defaultPorts[dbType]
+		}
+
+		dump, err := dumpCommand(dbType, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Dumping %s database on %s:%d...", dbType, opts.host, opts.port)
+		dumpPath, err := dumpToFile(dump)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.Remove(dumpPath)
+		info, err := os.Stat(dumpPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		root, err := environment.LoadRoot()
+		if err != nil {
+			_ = os.Remove(dumpPath)
+			log.Fatal(err)
+		}
+
+		share, err := sdk.CreateShare(root, &sdk.ShareRequest{
+			BackendMode: sdk.TcpTunnelBackendMode,
+			ShareMode:   sdk.PrivateShareMode,
+			Target:      "db-snapshot",
+		})
+		if err != nil {
+			_ = os.Remove(dumpPath)
+			log.Fatal(err)
+		}
+
+		log.Printf("Snapshot ready (%d bytes compressed). Let others load it using:\n\n  devlink db restore %s --port <local-port>\n", info.Size(), share.Token)
+
+		listener, err := sdk.NewListener(share.Token, root)
+		if err != nil {
+			_ = sdk.DeleteShare(root, share)
+			_ = os.Remove(dumpPath)
+			log.Fatal(err)
+		}
+
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-c
+			log.Println("Shutting down db snapshot...")
+			_ = listener.Close()
+		}()
+
+		name := opts.database
+		if name == "" {
+			name = dbType
+		}
+		format, ext := "sql", ".sql.gz"
+		if dbType == "postgres" {
+			format, ext = "custom", ".dump.gz"
+		}
+		header := internal.FrameHeader{
+			Kind: "db-snapshot",
+			Name: name + ext,
+			Size: info.Size(),
+			Meta: map[string]string{"type": dbType, "database": opts.database, "format": format, "compression": "gzip"},
+		}
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				f, err := os.Open(dumpPath)
+				if err != nil {
+					log.Printf("error opening snapshot: %v", err)
+					return
+				}
+				defer f.Close()
+
+				if _, err := internal.WriteFrame(c, header, f); err != nil {
+					log.Printf("error sending snapshot: %v", err)
+					return
+				}
+				log.Printf("Snapshot sent to a teammate")
+			}(conn)
+		}
+
+		if err := sdk.DeleteShare(root, share); err != nil {
+			log.Printf("error deleting share: %v", err)
+		}
+	},
+}
+
+func init() {
+	dbSnapshotCmd.Flags().String("type", "postgres", "database type: postgres or mysql")
+	dbSnapshotCmd.Flags().String("host", "127.0.0.1", "database host")
+	dbSnapshotCmd.Flags().Int("port", 0, "database port (default: 5432 for postgres, 3306 for mysql)")
+	dbSnapshotCmd.Flags().String("user", "", "database user")
+	dbSnapshotCmd.Flags().String("database", "", "database to dump (default: all for mysql, the user's for postgres)")
+	dbSnapshotCmd.Flags().Bool("schema-only", false, "dump the schema without data")
+	dbSnapshotCmd.Flags().StringSlice("tables", nil, "only dump these tables")
+}
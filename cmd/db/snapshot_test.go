@@ -0,0 +1,35 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMysqlDumpCommand(t *testing.T) {
+	tests := []struct {
+		opts dumpOptions
+		want []string
+	}{
+		{dumpOptions{database: "app"}, []string{"app"}},
+		{dumpOptions{database: "app", tables: []string{"users", "orders"}}, []string{"app", "users", "orders"}},
+		{dumpOptions{}, []string{"--all-databases"}},
+	}
+	for _, tt := range tests {
+		tt.opts.host, tt.opts.port = "127.0.0.1", 3306
+		cmd, err := dumpCommand("mysql", tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cmd.Args[len(cmd.Args)-len(tt.want):]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("dumpCommand(%+v) args = %q, want them to end in %q", tt.opts, cmd.Args, tt.want)
+		}
+		for _, a := range cmd.Args {
+			if a == "--databases" {
+				t.Errorf("dumpCommand(%+v) args = %q use --databases", tt.opts, cmd.Args)
+			}
+		}
+	}
+	if _, err := dumpCommand("mysql", dumpOptions{tables: []string{"users"}}); err == nil {
+		t.Error("--tables without --database accepted")
+	}
+}
@@ -0,0 +1,344 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sqlDialect describes enough of a SQL dialect to split queries into
+// statements and decide whether a statement is safe on a read-only share.
+// This is a lexical check, not a parser: statements must start with one of
+// readVerbs and may not contain any of writeWords outside literals.
+type sqlDialect struct {
+	backslashEscapes bool // '...' and "..." strings use backslash escapes (MySQL)
+	eStrings         bool // E'...' strings use backslash escapes (PostgreSQL)
+	hashComments     bool // # starts a line comment (MySQL)
+	backticks        bool // `...` quotes identifiers (MySQL)
+	doubleQuoteIdent bool // "..." quotes identifiers, U&"..." with escapes (PostgreSQL)
+	dollarQuotes     bool // $tag$...$tag$ strings (PostgreSQL)
+	execComments     bool // /*! ... */ comments are executed (MySQL)
+	nestedComments   bool // /* ... */ comments nest (PostgreSQL)
+	dashSpace        bool // -- only starts a comment before whitespace (MySQL)
+
+	readVerbs  map[string]bool // statements a read-only share accepts
+	writeWords map[string]bool // may not appear anywhere, e.g. in a data-modifying CTE
+	lexWords   map[string]bool // settings that change how literals are lexed
+}
+
+func words(ws ...string) map[string]bool {
+	m := make(map[string]bool, len(ws))
+	for _, w := range ws {
+		m[w] = true
+	}
+	return m
+}
+
+// sqlWord is a word or quoted identifier of a statement, or a string
+// literal, and its parenthesis depth.
+type sqlWord struct {
+	word  string // upper-cased and unquoted, or sqlLiteral
+	depth int
+}
+
+// sqlLiteral stands for a string literal, whatever its contents.
+const sqlLiteral = "'"
+
+// split splits a query into statements of words, skipping comments.
+// Quoted identifiers are unquoted so they are checked like plain words.
+func (d *sqlDialect) split(query string) [][]sqlWord {
+	var stmts [][]sqlWord
+	var cur []sqlWord
+	depth := 0
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ';':
+			if len(cur) > 0 {
+				stmts = append(stmts, cur)
+			}
+			cur, depth = nil, 0
+			i++
+		case c == '(':
+			depth++
+			i++
+		case c == ')':
+			depth--
+			i++
+		case c == '-' && strings.HasPrefix(query[i:], "--") && (!d.dashSpace || i+2 >= len(query) || query[i+2] <= ' '),
+			c == '#' && d.hashComments:
+			if j := strings.IndexByte(query[i:], '\n'); j >= 0 {
+				i += j + 1
+			} else {
+				i = len(query)
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*!") && d.execComments:
+			// executed by the server: skip only the marker and version
+			i += 3
+			for i < len(query) && query[i] >= '0' && query[i] <= '9' {
+				i++
+			}
+		case c == '*' && strings.HasPrefix(query[i:], "*/") && d.execComments:
+			i += 2
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			i = skipBlockComment(query, i, d.nestedComments)
+		case c == '`' && d.backticks, c == '"' && d.doubleQuoteIdent:
+			j := skipQuoted(query, i, c, false)
+			ident := unquoteIdent(query[i:j], c)
+			if c == '"' && unicodeEscaped(query, i) {
+				if len(cur) > 0 && cur[len(cur)-1].word == "U" {
+					cur = cur[:len(cur)-1] // the U of U&
+				}
+				ident = unescapeUnicode(ident)
+			}
+			cur = append(cur, sqlWord{word: strings.ToUpper(ident), depth: depth})
+			i = j
+		case c == '\'' || c == '"':
+			escapes := d.backslashEscapes || c == '\'' && d.eStrings && eString(query, i)
+			i = skipQuoted(query, i, c, escapes)
+			cur = append(cur, sqlWord{word: sqlLiteral, depth: depth})
+		case c == '$' && d.dollarQuotes:
+			j := skipDollarQuoted(query, i)
+			if j > i+1 {
+				cur = append(cur, sqlWord{word: sqlLiteral, depth: depth})
+			}
+			i = j
+		case isWordStart(c):
+			j := i + 1
+			for j < len(query) && isWordPart(query[j]) {
+				j++
+			}
+			cur = append(cur, sqlWord{word: strings.ToUpper(query[i:j]), depth: depth})
+			i = j
+		default:
+			i++
+		}
+	}
+	if len(cur) > 0 {
+		stmts = append(stmts, cur)
+	}
+	return stmts
+}
+
+func isWordStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isWordPart(c byte) bool {
+	return isWordStart(c) || c >= '0' && c <= '9' || c == '$'
+}
+
+// eString reports whether the quote at i starts an E'...' string, which
+// allows backslash escapes in PostgreSQL. The E must be a word of its own:
+// in name'...' it ends an identifier.
+func eString(query string, i int) bool {
+	return i > 0 && (query[i-1] == 'e' || query[i-1] == 'E') && (i == 1 || !isWordPart(query[i-2]))
+}
+
+// unicodeEscaped reports whether the quote at i is preceded by a U& prefix.
+func unicodeEscaped(query string, i int) bool {
+	return i > 1 && query[i-1] == '&' && (query[i-2] == 'u' || query[i-2] == 'U') &&
+		(i == 2 || !isWordPart(query[i-3]))
+}
+
+// unquoteIdent strips the quotes of a quoted identifier and undoubles
+// quotes inside it; an unterminated identifier runs to the end.
+func unquoteIdent(quoted string, quote byte) string {
+	s := quoted[1:]
+	if len(s) > 0 && s[len(s)-1] == quote {
+		s = s[:len(s)-1]
+	}
+	q := string(quote)
+	return strings.ReplaceAll(s, q+q, q)
+}
+
+// unescapeUnicode decodes the \XXXX and \+XXXXXX escapes of a U&"..."
+// identifier. A different escape character chosen with UESCAPE is not
+// decoded; read-only shares refuse UESCAPE.
+func unescapeUnicode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		if s[i+1] == '\\' {
+			b.WriteByte('\\')
+			i++
+			continue
+		}
+		start, n := i+1, 4
+		if s[i+1] == '+' {
+			start, n = i+2, 6
+		}
+		if start+n > len(s) {
+			b.WriteString(s[i:])
+			break
+		}
+		r, err := strconv.ParseUint(s[start:start+n], 16, 32)
+		if err != nil {
+			b.WriteByte(s[i])
+			continue
+		}
+		b.WriteRune(rune(r))
+		i = start + n - 1
+	}
+	return b.String()
+}
+
+func skipBlockComment(query string, i int, nested bool) int {
+	nest := 0
+	for i < len(query) {
+		switch {
+		case strings.HasPrefix(query[i:], "/*") && (nested || nest == 0):
+			nest++
+			i += 2
+		case strings.HasPrefix(query[i:], "*/"):
+			nest--
+			i += 2
+			if nest == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+func skipQuoted(query string, i int, quote byte, escapes bool) int {
+	for i++; i < len(query); i++ {
+		switch {
+		case escapes && query[i] == '\\':
+			i++
+		case query[i] == quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return i
+}
+
+// skipDollarQuoted skips $tag$...$tag$; $1 style parameters are skipped
+// as single characters.
+func skipDollarQuoted(query string, i int) int {
+	j := i + 1
+	for j < len(query) && query[j] != '$' && (isWordStart(query[j]) || j > i+1 && isWordPart(query[j])) {
+		j++
+	}
+	if j >= len(query) || query[j] != '$' {
+		return i + 1
+	}
+	tag := query[i : j+1]
+	if end := strings.Index(query[j+1:], tag); end >= 0 {
+		return j + 1 + end + len(tag)
+	}
+	return len(query)
+}
+
+// clientSafe checks that a script statement ending in ';' is passed on as
+// it is by the command line client: it may not contain a backslash outside
+// literals and quoted names, where it would start a client command, and
+// the final ';' must not be inside a literal or comment.
+func (d *sqlDialect) clientSafe(stmt string) error {
+	end := false // the final ';' was reached as code
+	for i := 0; i < len(stmt); {
+		c := stmt[i]
+		j := i + 1
+		comment := false
+		switch {
+		case c == '\\':
+			return errors.New("contains a client command (backslash)")
+		case c == '-' && strings.HasPrefix(stmt[i:], "--") && (!d.dashSpace || i+2 >= len(stmt) || stmt[i+2] <= ' '),
+			c == '#' && d.hashComments:
+			if k := strings.IndexByte(stmt[i:], '\n'); k >= 0 {
+				j = i + k + 1
+			} else {
+				j = len(stmt)
+			}
+			comment = true
+		case c == '/' && strings.HasPrefix(stmt[i:], "/*") && !(d.execComments && strings.HasPrefix(stmt[i:], "/*!")):
+			j = skipBlockComment(stmt, i, d.nestedComments)
+			comment = true
+		case c == '`' && d.backticks, c == '"' && d.doubleQuoteIdent:
+			j = skipQuoted(stmt, i, c, false)
+		case c == '\'' || c == '"':
+			j = skipQuoted(stmt, i, c, d.backslashEscapes || c == '\'' && d.eStrings && eString(stmt, i))
+		case c == '$' && d.dollarQuotes:
+			j = skipDollarQuoted(stmt, i)
+		case c == ';' && j == len(stmt):
+			end = true
+		}
+		// clients disagree on backslashes in comments, so refuse them too
+		if comment && strings.ContainsRune(stmt[i:j], '\\') {
+			return errors.New("contains a backslash in a comment")
+		}
+		i = j
+	}
+	if !end {
+		return errors.New("ends inside a literal or comment")
+	}
+	return nil
+}
+
+// readOnly rejects queries with statements that may modify data or schema,
+// or switch the session out of read-only mode.
+func (d *sqlDialect) readOnly(query string) error {
+	for _, stmt := range d.split(query) {
+		if err := d.readOnlyStatement(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *sqlDialect) readOnlyStatement(words []sqlWord) error {
+	verb := words[0].word
+	if !d.readVerbs[verb] {
+		return fmt.Errorf("%s is not allowed on a read-only share", verb)
+	}
+	for i, w := range words {
+		switch {
+		case d.writeWords[w.word]:
+			return fmt.Errorf("%s is not allowed on a read-only share", w.word)
+		case w.word == "INTO" && w.depth == 0:
+			return fmt.Errorf("%s ... INTO is not allowed on a read-only share", verb)
+		case strings.HasSuffix(w.word, "READ_ONLY"),
+			w.word == "WRITE" && i > 0 && words[i-1].word == "READ":
+			return errors.New("leaving read-only mode is not allowed on a read-only share")
+		case d.lexWords[w.word]:
+			return fmt.Errorf("changing %s is not allowed on a read-only share", w.word)
+		}
+	}
+	if verb == "COPY" {
+		return copyToStdout(words)
+	}
+	return nil
+}
+
+// copyToStdout accepts COPY statements that send their rows to the client:
+// the first TO outside parentheses must be followed by STDOUT, not by a
+// file name or PROGRAM, which would write or run something on the server.
+func copyToStdout(words []sqlWord) error {
+	for i, w := range words {
+		if w.depth != 0 {
+			continue
+		}
+		switch w.word {
+		case "FROM":
+			return errors.New("COPY ... FROM is not allowed on a read-only share")
+		case "PROGRAM":
+			return errors.New("COPY ... PROGRAM is not allowed on a read-only share")
+		case "TO":
+			if i+1 < len(words) && words[i+1].word == "STDOUT" && words[i+1].depth == 0 {
+				return nil
+			}
+			return errors.New("only COPY ... TO STDOUT is allowed on a read-only share")
+		}
+	}
+	return errors.New("only COPY ... TO STDOUT is allowed on a read-only share")
+}
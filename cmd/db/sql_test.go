@@ -0,0 +1,140 @@
+package db
+
+import "testing"
+
+func TestPgReadOnly(t *testing.T) {
+	tests := []struct {
+		query string
+		ok    bool
+	}{
+		{"SELECT 1", true},
+		{"select * from users where name = 'x'; select 2", true},
+		{`SELECT * FROM "Users" WHERE "select" = 1`, true},
+		{"SELECT 'DELETE FROM t; UPDATE t SET a = 1'", true},
+		{"SELECT $$ DROP TABLE t $$, $tag$ INSERT $tag$", true},
+		{"SELECT 1 /* DELETE /* nested */ FROM t */", true},
+		{"SELECT 1 -- DELETE FROM t", true},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", true},
+		{"EXPLAIN SELECT 1", true},
+		{"BEGIN; SELECT 1; COMMIT", true},
+		{"SHOW search_path", true},
+		{"SET search_path = public", true},
+		{`SELECT E'\'; DELETE FROM t; --'`, true},
+		{"COPY users TO STDOUT", true},
+		{"COPY (SELECT 1) TO STDOUT WITH (FORMAT csv)", true},
+
+		{"INSERT INTO t VALUES (1)", false},
+		{"SELECT 1; DROP TABLE t", false},
+		{"WITH x AS (DELETE FROM t RETURNING *) SELECT * FROM x", false},
+		{"SELECT * INTO t2 FROM t", false},
+		{"SET default_transaction_read_only = off", false},
+		{"SET SESSION CHARACTERISTICS AS TRANSACTION READ WRITE", false},
+		{"BEGIN READ WRITE", false},
+		{"SELECT set_config('default_transaction_read_only', 'off', false)", false},
+		{"DO $$ BEGIN DELETE FROM t; END $$", false},
+		{"SELECT lo_export(16384, '/tmp/x')", false},
+		{"COPY t FROM STDIN", false},
+		{"COPY t", false},
+
+		// COPY may only send rows to the client
+		{"COPY (SELECT 1 AS stdout) TO PROGRAM 'sh -c id'", false},
+		{"COPY t TO PROGRAM 'sh -c id'", false},
+		{"COPY t TO '/tmp/out'", false},
+		{"COPY t TO E'/tmp/out'", false},
+		{"COPY (SELECT 'to stdout') TO '/tmp/out'", false},
+		{"COPY t (stdout) TO '/tmp/out'", false},
+
+		// an E at the end of a word does not start an E'...' string
+		{`SELECT name'\'; SET default_transaction_read_only=off; --'`, false},
+		{`SELECT name'\'; DELETE FROM t; --'`, false},
+
+		// quoted identifiers are checked like plain words
+		{`SELECT "set_config"('default_transaction_read_only', 'off', false)`, false},
+		{`SELECT pg_catalog."set_config"('default_transaction_read_only', 'off', false)`, false},
+		{`SET "default_transaction_read_only" = off`, false},
+		{`SELECT U&"\0073et_config"('default_transaction_read_only', 'off', false)`, false},
+		{`SELECT u&"\+000073et_config"('default_transaction_read_only', 'off', false)`, false},
+		{`SELECT U&"!0073et_config" UESCAPE '!' ('default_transaction_read_only', 'off', false)`, false},
+
+		// string lexing must stay what the check assumes
+		{"SET standard_conforming_strings = off", false},
+		{"SET standard_conforming_strings TO off; SELECT 'a\\'; DELETE FROM t; --'", false},
+	}
+	for _, tt := range tests {
+		err := pgSQL.readOnly(tt.query)
+		if (err == nil) != tt.ok {
+			t.Errorf("readOnly(%q) = %v, want ok %v", tt.query, err, tt.ok)
+		}
+	}
+}
+
+func TestPgSplit(t *testing.T) {
+	stmts := pgSQL.split(`SELECT "a""b", U&"\0041" FROM t; ; SELECT 'x;y'`)
+	if len(stmts) != 2 {
+		t.Fatalf("got %d statements, want 2", len(stmts))
+	}
+	var got []string
+	for _, w := range stmts[0] {
+		got = append(got, w.word)
+	}
+	want := []string{"SELECT", `A"B`, "A", "FROM", "T"}
+	if len(got) != len(want) {
+		t.Fatalf("words = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("words = %q, want %q", got, want)
+		}
+	}
+	if w := stmts[1][1]; w.word != sqlLiteral {
+		t.Errorf("string literal lexed as %q", w.word)
+	}
+}
+
+func TestMysqlReadOnly(t *testing.T) {
+	tests := []struct {
+		query string
+		ok    bool
+	}{
+		{"SELECT 1", true},
+		{"SELECT * FROM `orders` WHERE note = 'a;b'", true},
+		{"SELECT 'it''s', \"quoted\"", true},
+		{"SELECT 1 # DELETE FROM t", true},
+		{"SELECT 1 -- DELETE FROM t", true},
+		{"SHOW TABLES; DESCRIBE users", true},
+		{"SET NAMES utf8mb4", true},
+		{"USE app", true},
+
+		{"UPDATE t SET c = 1", false},
+		{"SELECT 1--1; DELETE FROM t", false},
+		{"SELECT * FROM t INTO OUTFILE '/tmp/x'", false},
+		{"SELECT 1 /*! ; DELETE FROM t */", false},
+		{"SET GLOBAL read_only = 0", false},
+		{"SET SESSION TRANSACTION READ WRITE", false},
+		{"SET @@session.transaction_read_only = 0", false},
+		{"SET tx_read_only = 0", false},
+
+		// backticked names are checked like plain words
+		{"SET `transaction_read_only` = 0", false},
+		{"SET @@session.`transaction_read_only` = OFF", false},
+		{"SELECT 1; `DELETE` FROM t", false},
+
+		// sql_mode changes how strings end, so it may not be changed
+		{"SET sql_mode = 'NO_BACKSLASH_ESCAPES'", false},
+		{"SET @@SESSION.sql_mode = 'ANSI_QUOTES'", false},
+		{"SET `sql_mode` = ''", false},
+
+		// and queries must be read-only under any mode the server may default to
+		{`WITH x AS (SELECT 'a\') UPDATE t SET c=1 -- ') SELECT 1`, false},
+		{`SELECT 'a\'; SET transaction_read_only=OFF; -- '`, false},
+		{`SELECT "a\"; DELETE FROM t; -- "`, false},
+		// E'...' is PostgreSQL: in MySQL the e is a name and '\' a whole string
+		{`SELECT e'\'; DELETE FROM t; -- '`, false},
+	}
+	for _, tt := range tests {
+		err := mysqlReadOnly(tt.query)
+		if (err == nil) != tt.ok {
+			t.Errorf("mysqlReadOnly(%q) = %v, want ok %v", tt.query, err, tt.ok)
+		}
+	}
+}
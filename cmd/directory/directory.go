@@ -10,4 +10,5 @@ var DirectoryCmd = &cobra.Command{
 func init() {
 	DirectoryCmd.AddCommand(directoryShareCmd)
 	DirectoryCmd.AddCommand(directoryGetCmd)
+	DirectoryCmd.AddCommand(directoryDownloadCmd)
 }
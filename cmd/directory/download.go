@@ -0,0 +1,73 @@
+package directory
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/devlink-sh/devlink/internal"
+	"github.com/openziti/zrok/environment"
+	"github.com/openziti/zrok/sdk/golang/sdk"
+	"github.com/spf13/cobra"
+)
+
+var directoryDownloadCmd = &cobra.Command{
+	Use:   "download <token> <path> [dest]",
+	Short: "Download a single file from a shared directory",
+	Long: `Download one file from a directory share and verify its length and SHA-256
+checksum. Partial downloads are removed. Example: devlink dir download <token> build/app.zip`,
+	Args: cobra.RangeArgs(2, 3),
+	Run: func(cmd *cobra.Command, args []string) {
+		token := args[0]
+		remotePath := strings.TrimPrefix(path.Clean("/"+args[1]), "/")
+		dest := path.Base(remotePath)
+		if remotePath == "" {
+			dest = "" // a shared file itself: keep its name
+		}
+		if len(args) == 3 {
+			dest = args[2]
+		}
+
+		root, err := environment.LoadRoot()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		acc, err := sdk.CreateAccess(root, &sdk.AccessRequest{ShareToken: token})
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer sdk.DeleteAccess(root, acc)
+
+		// Plain HTTP request, but every connection goes through the tunnel
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return sdk.NewDialer(token, root)
+			},
+		}}
+		resp, err := client.Get("http://devlink" + framePrefix + (&url.URL{Path: remotePath}).EscapedPath())
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			log.Fatalf("error downloading %s: %s", remotePath, strings.TrimSpace(string(body)))
+		}
+
+		frame, n, err := internal.SaveFrame(resp.Body, dest)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if dest == "" {
+			dest = filepath.Base(frame.Header.Name)
+		}
+		log.Printf("Downloaded %s (%d bytes, sha256 %s) -> %s", remotePath, n, frame.Sum(), dest)
+	},
+}
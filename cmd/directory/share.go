@@ -7,6 +7,7 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/devlink-sh/devlink/internal"
 	"github.com/openziti/zrok/environment"
 	"github.com/openziti/zrok/sdk/golang/sdk"
 	"github.com/spf13/cobra"
@@ -37,7 +38,7 @@ var directoryShareCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 
-		log.Printf("Directory share ready! Teammates can run:\n  devlink dir get %s 8080\n", share.Token)
+		log.Printf("Directory share ready! Teammates can run:\n  devlink dir get %s 8080\nor download a single file with:\n  devlink dir download %s <path>\n", share.Token, share.Token)
 
 		listener, err := sdk.NewListener(share.Token, root)
 		if err != nil {
@@ -45,8 +46,11 @@ var directoryShareCmd = &cobra.Command{
 		}
 		defer listener.Close()
 
+		mux := http.NewServeMux()
+		mux.Handle("/", http.FileServer(http.Dir(dir)))
+		mux.Handle(framePrefix, http.StripPrefix(framePrefix, frameHandler(dir)))
 		server := &http.Server{
-			Handler: http.FileServer(http.Dir(dir)),
+			Handler: mux,
 		}
 
 		// Cleanup on exit
@@ -67,3 +71,30 @@ var directoryShareCmd = &cobra.Command{
 		}
 	},
 }
+
+// framePrefix serves files wrapped in a devlink frame, so `dir download` can
+// verify the length and checksum of what it received.
+const framePrefix = "/.devlink/frame/"
+
+func frameHandler(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, err := http.Dir(dir).Open(r.URL.Path)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			http.Error(w, "not a file", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		header := internal.FrameHeader{Kind: "file", Name: info.Name(), Size: info.Size()}
+		if _, err := internal.WriteFrame(w, header, f); err != nil {
+			log.Printf("error sending %s: %v", r.URL.Path, err)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package env
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var envDiffCmd = &cobra.Command{
+	Use:   "diff <token> [local-file]",
+	Short: "Compare shared environment variables with a local .env file",
+	Long: `Fetch a teammate's shared environment and compare it with a local file
+(default .env). Values are masked, so the output is safe to paste into chat.
+Like diff, it exits with status 1 when there are differences.
+Example: devlink env diff <token> .env.local`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		token := args[0]
+		localPath := ".env"
+		if len(args) == 2 {
+			localPath = args[1]
+		}
+		showValues, _ := cmd.Flags().GetBool("show-values")
+
+		localData, err := os.ReadFile(localPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		local, err := parseDotenv(localData)
+		if err != nil {
+			log.Fatalf("error parsing %s: %v", localPath, err)
+		}
+
+		remoteData, err := receiveEnv(token)
+		if err != nil {
+			log.Fatal(err)
+		}
+		remote, err := parseDotenv(remoteData)
+		if err != nil {
+			log.Fatalf("error parsing shared env: %v", err)
+		}
+
+		display := maskedValue
+		if showValues {
+			display = func(v string) string { return fmt.Sprintf("%q", v) }
+		}
+
+		localByKey := map[string]string{}
+		for _, v := range local {
+			localByKey[v.Key] = v.Value
+		}
+		remoteByKey := map[string]string{}
+		for _, v := range remote {
+			remoteByKey[v.Key] = v.Value
+		}
+
+		changes := 0
+		for _, v := range remote {
+			mine, ok := localByKey[v.Key]
+			switch {
+			case !ok:
+				fmt.Printf("+ %s=%s\n", v.Key, display(v.Value))
+			case mine != v.Value:
+				fmt.Printf("~ %s: %s -> %s\n", v.Key, display(mine), display(v.Value))
+			default:
+				continue
+			}
+			changes++
+		}
+		for _, v := range local {
+			if _, ok := remoteByKey[v.Key]; !ok {
+				fmt.Printf("- %s=%s\n", v.Key, display(v.Value))
+				changes++
+			}
+		}
+
+		if changes == 0 {
+			log.Printf("No differences: %d variables match %s", len(remote), localPath)
+			return
+		}
+		log.Printf("%d difference(s) between %s (-) and the shared env (+)", changes, localPath)
+		os.Exit(1)
+	},
+}
+
+// maskedValue hides a value entirely; even a hash of a short secret could be
+// brute-forced from a pasted diff.
+func maskedValue(v string) string {
+	if v == "" {
+		return "(empty)"
+	}
+	return "****"
+}
+
+func init() {
+	envDiffCmd.Flags().Bool("show-values", false, "print values in clear text instead of masking them")
+}
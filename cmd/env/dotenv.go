@@ -0,0 +1,156 @@
+package env
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// envVar is a single KEY=VALUE pair, kept in file order.
+type envVar struct {
+	Key   string
+	Value string
+}
+
+// parseDotenv parses the contents of a .env file. It understands comments,
+// blank lines, an optional `export` prefix, and single/double quoted values
+// (double quoted values may span lines and use \n, \t, \" and \\ escapes).
+func parseDotenv(data []byte) ([]envVar, error) {
+	var vars []envVar
+	index := map[string]int{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNo)
+		}
+		key := strings.TrimSpace(line[:eq])
+		if !validEnvKey(key) {
+			return nil, fmt.Errorf("line %d: invalid variable name %q", lineNo, key)
+		}
+		raw := strings.TrimSpace(line[eq+1:])
+
+		var value string
+		switch {
+		case strings.HasPrefix(raw, `"`):
+			// Double quoted values may continue on following lines
+			end := closingQuote(raw[1:], '"')
+			for end < 0 {
+				if !scanner.Scan() {
+					return nil, fmt.Errorf("line %d: unterminated quoted value for %s", lineNo, key)
+				}
+				lineNo++
+				raw += "\n" + scanner.Text()
+				end = closingQuote(raw[1:], '"')
+			}
+			value = unescapeDouble(raw[1 : end+1])
+		case strings.HasPrefix(raw, "'"):
+			// Single quoted values are literal; 'it'\''s' style escapes from
+			// shell exports are joined back together
+			var ok bool
+			for value, ok = singleQuoted(raw); !ok; value, ok = singleQuoted(raw) {
+				if !scanner.Scan() {
+					return nil, fmt.Errorf("line %d: unterminated quoted value for %s", lineNo, key)
+				}
+				lineNo++
+				raw += "\n" + scanner.Text()
+			}
+		default:
+			// Strip trailing inline comments: FOO=bar # comment
+			if i := strings.Index(raw, " #"); i >= 0 {
+				raw = raw[:i]
+			}
+			value = strings.TrimSpace(raw)
+		}
+
+		if i, ok := index[key]; ok {
+			vars[i].Value = value
+			continue
+		}
+		index[key] = len(vars)
+		vars = append(vars, envVar{Key: key, Value: value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+func validEnvKey(key string) bool {
+	for i, r := range key {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		case r == '.' || r == '-':
+			// tolerated by most dotenv loaders
+		default:
+			return false
+		}
+	}
+	return key != ""
+}
+
+// closingQuote returns the index of the first unescaped quote in s, or -1.
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// singleQuoted joins the shell single-quoted segments at the start of s.
+// It reports false if the last segment is not terminated yet.
+func singleQuoted(s string) (string, bool) {
+	var b strings.Builder
+	for strings.HasPrefix(s, "'") {
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", false
+		}
+		b.WriteString(s[1 : end+1])
+		s = s[end+2:]
+		for strings.HasPrefix(s, `\'`) {
+			b.WriteByte('\'')
+			s = s[2:]
+		}
+	}
+	return b.String(), true
+}
+
+func unescapeDouble(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
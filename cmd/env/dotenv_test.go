@@ -0,0 +1,56 @@
+package env
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []envVar
+	}{
+		{"plain", "A=1\nB=two", []envVar{{"A", "1"}, {"B", "two"}}},
+		{"comments and blanks", "# header\n\nA=1 # trailing\n  \n", []envVar{{"A", "1"}}},
+		{"export prefix", "export A=1", []envVar{{"A", "1"}}},
+		{"spaces around equals", "A = 1 ", []envVar{{"A", "1"}}},
+		{"empty value", "A=", []envVar{{"A", ""}}},
+		{"hash without space", "A=abc#def", []envVar{{"A", "abc#def"}}},
+		{"equals in value", "URL=postgres://u:p@h/db?x=1", []envVar{{"URL", "postgres://u:p@h/db?x=1"}}},
+		{"double quoted escapes", `A="a\nb\t\"c\"\\"`, []envVar{{"A", "a\nb\t\"c\"\\"}}},
+		{"double quoted keeps hash", `A="x # y"`, []envVar{{"A", "x # y"}}},
+		{"double quoted multiline", "A=\"line1\nline2\"\nB=2", []envVar{{"A", "line1\nline2"}, {"B", "2"}}},
+		{"single quoted literal", `A='a\nb $HOME'`, []envVar{{"A", `a\nb $HOME`}}},
+		{"single quoted shell escape", `A='it'\''s'`, []envVar{{"A", "it's"}}},
+		{"single quoted multiline", "A='x\ny'", []envVar{{"A", "x\ny"}}},
+		{"duplicate keeps first position", "A=1\nB=2\nA=3", []envVar{{"A", "3"}, {"B", "2"}}},
+		{"dotted and dashed keys", "a.b=1\nc-d=2", []envVar{{"a.b", "1"}, {"c-d", "2"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDotenv([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("parseDotenv(%q): %v", tt.in, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDotenv(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDotenvErrors(t *testing.T) {
+	for _, in := range []string{
+		"NOEQUALS",
+		"=value",
+		"1A=x",
+		"A B=x",
+		`A="unterminated`,
+		"A='unterminated\nstill",
+	} {
+		if vars, err := parseDotenv([]byte(in)); err == nil {
+			t.Errorf("parseDotenv(%q) = %q, want error", in, vars)
+		}
+	}
+}
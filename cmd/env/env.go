@@ -13,4 +13,6 @@ var EnvCmd = &cobra.Command{
 func init() {
 	EnvCmd.AddCommand(envShareCmd)
 	EnvCmd.AddCommand(envGetCmd)
+	EnvCmd.AddCommand(envExecCmd)
+	EnvCmd.AddCommand(envDiffCmd)
 }
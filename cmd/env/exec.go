@@ -0,0 +1,79 @@
+package env
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+var envExecCmd = &cobra.Command{
+	Use:   "exec <token> -- <command> [args...]",
+	Short: "Run a command with shared environment variables",
+	Long: `Fetch shared environment variables and inject them into a child process
+without writing them to disk. Example: devlink env exec <token> -- npm run dev`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if cmd.ArgsLenAtDash() != 1 || len(args) < 2 {
+			return errors.New("usage: devlink env exec <token> -- <command> [args...]")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		token := args[0]
+
+		data, err := receiveEnv(token)
+		if err != nil {
+			log.Fatal(err)
+		}
+		vars, err := parseDotenv(data)
+		if err != nil {
+			log.Fatalf("error parsing shared env: %v", err)
+		}
+		log.Printf("Loaded %d variables, starting %s", len(vars), args[1])
+
+		child := exec.Command(args[1], args[2:]...)
+		child.Stdin = os.Stdin
+		child.Stdout = os.Stdout
+		child.Stderr = os.Stderr
+		child.Env = os.Environ()
+		for _, v := range vars {
+			child.Env = append(child.Env, v.Key+"="+v.Value)
+		}
+
+		// Survive signals instead of dying before the child. Ctrl+C and
+		// Ctrl+\ already reach the child, which shares our process group, so
+		// only SIGTERM and SIGHUP are passed on.
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+
+		if err := child.Start(); err != nil {
+			log.Fatalf("error starting %s: %v", args[1], err)
+		}
+		go func() {
+			for s := range sig {
+				if s == syscall.SIGTERM || s == syscall.SIGHUP {
+					_ = child.Process.Signal(s)
+				}
+			}
+		}()
+
+		err = child.Wait()
+		signal.Stop(sig)
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// Like a shell, report death by signal as 128+signal
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				os.Exit(128 + int(status.Signal()))
+			}
+			os.Exit(exitErr.ExitCode())
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
@@ -0,0 +1,181 @@
+package env
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envFormats lists the formats accepted by --format on env get and env share.
+var envFormats = []string{"dotenv", "json", "yaml", "shell", "k8s-secret", "docker-env"}
+
+func validFormat(format string) error {
+	for _, f := range envFormats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown format %q (want one of %s)", format, strings.Join(envFormats, ", "))
+}
+
+// decodeEnv parses variables from any of the supported formats.
+func decodeEnv(data []byte, format string) ([]envVar, error) {
+	switch format {
+	case "dotenv", "shell":
+		return parseDotenv(data)
+	case "docker-env":
+		return parseDockerEnv(data)
+	case "json", "yaml":
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		if len(doc.Content) == 0 {
+			return nil, nil
+		}
+		return mappingVars(doc.Content[0], false)
+	case "k8s-secret":
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("not a Kubernetes Secret manifest")
+		}
+		var vars []envVar
+		top := doc.Content[0].Content
+		for i := 0; i+1 < len(top); i += 2 {
+			switch top[i].Value {
+			case "data":
+				v, err := mappingVars(top[i+1], true)
+				if err != nil {
+					return nil, err
+				}
+				vars = append(vars, v...)
+			case "stringData":
+				v, err := mappingVars(top[i+1], false)
+				if err != nil {
+					return nil, err
+				}
+				vars = append(vars, v...)
+			}
+		}
+		return vars, nil
+	}
+	return nil, validFormat(format)
+}
+
+// parseDockerEnv parses a file for docker run --env-file, which unlike a
+// .env file has no quoting: everything after the first = is the value.
+// A bare KEY takes its value from the environment, as docker does.
+func parseDockerEnv(data []byte) ([]envVar, error) {
+	var vars []envVar
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimLeft(strings.TrimSuffix(line, "\r"), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, hasValue := strings.Cut(line, "=")
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: invalid variable name %q", i+1, key)
+		}
+		if !hasValue {
+			if value, hasValue = os.LookupEnv(key); !hasValue {
+				continue
+			}
+		}
+		vars = append(vars, envVar{Key: key, Value: value})
+	}
+	return vars, nil
+}
+
+// mappingVars turns a flat YAML/JSON mapping into variables, keeping key order.
+func mappingVars(node *yaml.Node, base64Values bool) ([]envVar, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("expected a flat object of KEY: value pairs")
+	}
+	var vars []envVar
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, val := node.Content[i].Value, node.Content[i+1]
+		if val.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("value of %s must be a string, number or bool", key)
+		}
+		value := val.Value
+		if val.Tag == "!!null" {
+			value = ""
+		}
+		if base64Values {
+			raw, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("value of %s is not valid base64: %w", key, err)
+			}
+			value = string(raw)
+		}
+		vars = append(vars, envVar{Key: key, Value: value})
+	}
+	return vars, nil
+}
+
+// encodeEnv renders variables in the requested format. name is only used for
+// the metadata.name of a Kubernetes Secret.
+func encodeEnv(vars []envVar, format, name string) ([]byte, error) {
+	var b bytes.Buffer
+	switch format {
+	case "dotenv":
+		for _, v := range vars {
+			fmt.Fprintf(&b, "%s=%s\n", v.Key, dotenvQuote(v.Value))
+		}
+	case "shell":
+		for _, v := range vars {
+			fmt.Fprintf(&b, "export %s='%s'\n", v.Key, strings.ReplaceAll(v.Value, "'", `'\''`))
+		}
+	case "docker-env":
+		for _, v := range vars {
+			if strings.ContainsAny(v.Value, "\r\n") {
+				return nil, fmt.Errorf("%s contains a newline, which docker env files cannot represent", v.Key)
+			}
+			fmt.Fprintf(&b, "%s=%s\n", v.Key, v.Value)
+		}
+	case "json":
+		b.WriteString("{")
+		for i, v := range vars {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			fmt.Fprintf(&b, "\n  %s: %s", jsonString(v.Key), jsonString(v.Value))
+		}
+		b.WriteString("\n}\n")
+	case "yaml":
+		// JSON strings are valid YAML double-quoted scalars
+		for _, v := range vars {
+			fmt.Fprintf(&b, "%s: %s\n", jsonString(v.Key), jsonString(v.Value))
+		}
+	case "k8s-secret":
+		fmt.Fprintf(&b, "apiVersion: v1\nkind: Secret\nmetadata:\n  name: %s\ntype: Opaque\ndata:\n", jsonString(name))
+		for _, v := range vars {
+			fmt.Fprintf(&b, "  %s: %s\n", jsonString(v.Key), base64.StdEncoding.EncodeToString([]byte(v.Value)))
+		}
+	default:
+		return nil, validFormat(format)
+	}
+	return b.Bytes(), nil
+}
+
+func jsonString(s string) string {
+	out, _ := json.Marshal(s)
+	return string(out)
+}
+
+// dotenvQuote leaves simple values bare and double-quotes everything else.
+func dotenvQuote(v string) string {
+	if v != "" && !strings.ContainsAny(v, " \t\r\n\"'\\#$`=") {
+		return v
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(v) + `"`
+}
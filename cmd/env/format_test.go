@@ -0,0 +1,46 @@
+package env
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDockerEnv(t *testing.T) {
+	t.Setenv("DEVLINK_TEST_FROM_ENV", "inherited")
+	in := "# comment\n\nPLAIN=1\nQUOTED=\"kept\"\nSINGLE='kept too'\n  INDENTED=x # not a comment\nEMPTY=\nDEVLINK_TEST_FROM_ENV\nDEVLINK_TEST_UNSET\r\nEQ=a=b\r\n"
+	want := []envVar{
+		{"PLAIN", "1"},
+		{"QUOTED", `"kept"`},
+		{"SINGLE", "'kept too'"},
+		{"INDENTED", "x # not a comment"},
+		{"EMPTY", ""},
+		{"DEVLINK_TEST_FROM_ENV", "inherited"},
+		{"EQ", "a=b"},
+	}
+	got, err := decodeEnv([]byte(in), "docker-env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := decodeEnv([]byte("BAD KEY=1\n"), "docker-env"); err == nil {
+		t.Error("want error for a key with whitespace")
+	}
+}
+
+func TestDockerEnvRoundTrip(t *testing.T) {
+	vars := []envVar{{"A", `"quoted"`}, {"B", "it's # fine"}, {"C", ""}}
+	out, err := encodeEnv(vars, "docker-env", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeEnv(out, "docker-env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, vars) {
+		t.Errorf("round trip = %q, want %q", got, vars)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/devlink-sh/devlink/internal"
 	"github.com/openziti/zrok/environment"
 	"github.com/openziti/zrok/sdk/golang/sdk"
 	"github.com/spf13/cobra"
@@ -14,46 +16,95 @@ import (
 var envGetCmd = &cobra.Command{
 	Use:   "get <token>",
 	Short: "Retrieve shared environment variables",
-	Long:  `Connect to a shared environment and save the received .env file in the current directory.`,
-	Args:  cobra.ExactArgs(1),
+	Long: `Connect to a shared environment and save the received .env file in the current directory.
+Use --format to convert it, e.g. --format k8s-secret -o - | kubectl apply -f -`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		token := args[0]
-
-		root, err := environment.LoadRoot()
-		if err != nil {
+		format, _ := cmd.Flags().GetString("format")
+		destPath, _ := cmd.Flags().GetString("output")
+		name, _ := cmd.Flags().GetString("name")
+		if err := validFormat(format); err != nil {
 			log.Fatal(err)
 		}
 
-		// create access so the service has a terminator to dial
-		acc, err := sdk.CreateAccess(root, &sdk.AccessRequest{ShareToken: token})
+		data, err := receiveEnv(token)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer func() {
-			if err := sdk.DeleteAccess(root, acc); err != nil {
-				log.Printf("error deleting access: %v", err)
+
+		// dotenv is the wire format, so it is written exactly as received
+		if format != "dotenv" {
+			vars, err := parseDotenv(data)
+			if err != nil {
+				log.Fatalf("error parsing shared env: %v", err)
 			}
-		}()
+			if data, err = encodeEnv(vars, format, name); err != nil {
+				log.Fatal(err)
+			}
+		}
 
-		// this returns a connected net.Conn (no Dial() needed)
-		conn, err := sdk.NewDialer(token, root)
-		if err != nil {
+		if destPath == "-" {
+			if _, err := os.Stdout.Write(data); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		if err := os.WriteFile(destPath, data, 0600); err != nil {
 			log.Fatal(err)
 		}
-		defer conn.Close()
 
-		destPath := filepath.Join(".", ".env.received")
-		out, err := os.Create(destPath)
-		if err != nil {
-			log.Fatal(err)
+		log.Printf("Received %d bytes -> %s", len(data), destPath)
+	},
+}
+
+func init() {
+	envGetCmd.Flags().String("format", "dotenv", "output format: "+strings.Join(envFormats, "|"))
+	envGetCmd.Flags().StringP("output", "o", filepath.Join(".", ".env.received"), "file to write, or - for stdout")
+	envGetCmd.Flags().String("name", "devlink-env", "metadata.name for --format k8s-secret")
+}
+
+// receiveEnv connects to an env share and returns the shared file contents.
+func receiveEnv(token string) ([]byte, error) {
+	root, err := environment.LoadRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	// create access so the service has a terminator to dial
+	acc, err := sdk.CreateAccess(root, &sdk.AccessRequest{ShareToken: token})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := sdk.DeleteAccess(root, acc); err != nil {
+			log.Printf("error deleting access: %v", err)
 		}
-		defer out.Close()
+	}()
 
-		n, err := io.Copy(out, conn) // stream until EOF
+	// this returns a connected net.Conn (no Dial() needed)
+	conn, err := sdk.NewDialer(token, root)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	frame, err := internal.NewFrameReader(conn)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(frame) // fails unless length and digest match
+	if err != nil {
+		return nil, err
+	}
+
+	// Recipient-pinned shares can only be opened with our local key
+	if internal.IsSealed(data) {
+		priv, err := internal.LoadKey()
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-
-		log.Printf("Received %d bytes -> %s", n, destPath)
-	},
+		return internal.Open(data, priv)
+	}
+	return data, nil
 }
@@ -1,12 +1,16 @@
 package env
 
 import (
+	"bytes"
 	"log"
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 
+	"github.com/devlink-sh/devlink/internal"
 	"github.com/spf13/cobra"
 
 	"github.com/openziti/zrok/environment"
@@ -16,18 +20,48 @@ import (
 var envShareCmd = &cobra.Command{
 	Use:   "share",
 	Short: "Share environment variables",
-	Long:  `Share environment variables with other commands`,
+	Long: `Share environment variables with other commands. Reads .env by default;
+use --file and --format to share e.g. a JSON, YAML or Kubernetes Secret file.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		root, err := environment.LoadRoot()
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		envFile, err := os.ReadFile(".env")
+		path, _ := cmd.Flags().GetString("file")
+		format, _ := cmd.Flags().GetString("format")
+		if err := validFormat(format); err != nil {
+			log.Fatal(err)
+		}
+
+		envFile, err := os.ReadFile(path)
 		if err != nil {
 			log.Fatal(err)
 		}
 
+		// Peers always receive dotenv, so convert other formats up front
+		if format != "dotenv" {
+			vars, err := decodeEnv(envFile, format)
+			if err != nil {
+				log.Fatalf("error parsing %s as %s: %v", path, format, err)
+			}
+			if envFile, err = encodeEnv(vars, "dotenv", ""); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		// Pin the share to specific teammates by sealing it for their keys
+		if to, _ := cmd.Flags().GetStringSlice("to"); len(to) > 0 {
+			recipients, err := internal.ResolveRecipients(to)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if envFile, err = internal.Seal(envFile, recipients); err != nil {
+				log.Fatalf("error encrypting env: %v", err)
+			}
+			log.Printf("Encrypted for %s", strings.Join(to, ", "))
+		}
+
 		share, err := sdk.CreateShare(root, &sdk.ShareRequest{
 			BackendMode: sdk.TcpTunnelBackendMode,
 			ShareMode:   sdk.PrivateShareMode,
@@ -65,7 +99,10 @@ var envShareCmd = &cobra.Command{
 				}
 				go func(c net.Conn) {
 					defer c.Close()
-					_, _ = c.Write(envFile)
+					header := internal.FrameHeader{Kind: "env", Name: filepath.Base(path), Size: int64(len(envFile))}
+					if _, err := internal.WriteFrame(c, header, bytes.NewReader(envFile)); err != nil {
+						log.Printf("error sending env: %v", err)
+					}
 				}(conn)
 			}
 		}()
@@ -74,3 +111,9 @@ var envShareCmd = &cobra.Command{
 
 	},
 }
+
+func init() {
+	envShareCmd.Flags().String("file", ".env", "file containing the variables to share")
+	envShareCmd.Flags().String("format", "dotenv", "input format: "+strings.Join(envFormats, "|"))
+	envShareCmd.Flags().StringSlice("to", nil, "only allow these contacts to read the share (see 'devlink keys')")
+}
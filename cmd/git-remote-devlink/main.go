@@ -0,0 +1,126 @@
+// Command git-remote-devlink is a git remote helper for devlink:// URLs.
+//
+// With the binary on PATH, git runs it for remotes such as
+//
+//	git clone devlink://<token>/<repo>.git
+//	git clone devlink://<secret>@<token>/<repo>.git   (for --auth-token shares)
+//
+// The helper opens a zrok access and a loopback tunnel for the duration of
+// that one git invocation and hands the smart HTTP conversation to git's own
+// remote-http helper, so no `devlink git connect` process is needed.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/devlink-sh/devlink/internal"
+	"github.com/openziti/zrok/environment"
+	"github.com/openziti/zrok/sdk/golang/sdk"
+)
+
+func main() {
+	log.SetPrefix("devlink: ")
+	log.SetFlags(0)
+
+	if len(os.Args) < 3 {
+		log.Fatal("usage: git-remote-devlink <remote> devlink://<token>/<repo>")
+	}
+	remoteName := os.Args[1]
+	secret, token, repo, err := parseURL(os.Args[2])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	code, err := run(remoteName, secret, token, repo)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(code)
+}
+
+// parseURL splits devlink://[<secret>@]<token>/<repo> into its parts.
+func parseURL(raw string) (string, string, string, error) {
+	rest := strings.TrimPrefix(raw, "devlink://")
+	host, repo, ok := strings.Cut(rest, "/")
+	repo = strings.Trim(repo, "/")
+	secret, token, hasSecret := strings.Cut(host, "@")
+	if !hasSecret {
+		secret, token = "", host
+	}
+	if rest == raw || !ok || token == "" || repo == "" {
+		return "", "", "", fmt.Errorf("invalid url %q, expected devlink://<token>/<repo>", raw)
+	}
+	if !strings.HasSuffix(strings.ToLower(repo), ".git") {
+		repo += ".git"
+	}
+	return secret, token, repo, nil
+}
+
+// run tunnels a loopback port to the share and runs `git remote-http`
+// against it, returning its exit code.
+func run(remoteName, secret, token, repo string) (int, error) {
+	root, err := environment.LoadRoot()
+	if err != nil {
+		return 0, err
+	}
+
+	acc, err := sdk.CreateAccess(root, &sdk.AccessRequest{ShareToken: token})
+	if err != nil {
+		return 0, err
+	}
+	defer sdk.DeleteAccess(root, acc)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			client, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				remote, err := sdk.NewDialer(token, root)
+				if err != nil {
+					log.Printf("error creating zrok connection: %v", err)
+					_ = c.Close()
+					return
+				}
+				internal.Pipe(c, remote)
+			}(client)
+		}
+	}()
+
+	httpURL := fmt.Sprintf("http://%s/%s", listener.Addr(), repo)
+	if strings.Contains(remoteName, "://") {
+		// git passes the URL as the remote name for anonymous remotes
+		remoteName = httpURL
+	}
+
+	var args []string
+	if secret != "" {
+		args = append(args, "-c", "http.extraHeader=Authorization: Bearer "+secret)
+	}
+	args = append(args, "remote-http", remoteName, httpURL)
+
+	helper := exec.Command("git", args...)
+	helper.Stdin = os.Stdin
+	helper.Stdout = os.Stdout
+	helper.Stderr = os.Stderr
+	err = helper.Run()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
@@ -0,0 +1,125 @@
+package git
+
+import (
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/devlink-sh/devlink/internal"
+	"github.com/openziti/zrok/environment"
+	"github.com/openziti/zrok/sdk/golang/sdk"
+	"github.com/spf13/cobra"
+)
+
+var gitBundleCmd = &cobra.Command{
+	Use:   "bundle <repo-path> [revs...]",
+	Short: "Share a repository as a git bundle",
+	Long: `Create a git bundle (all refs by default) and share it like an env file, so a
+teammate can fetch it with 'devlink git unbundle <token>' without a live
+git serve session. Use --output to write the bundle to a file instead.
+Example: devlink git bundle . main feature/login`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		repo, err := resolveRepo(args[0])
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		revs := args[1:]
+		if len(revs) == 0 {
+			revs = []string{"--all"}
+		}
+
+		tmpDir, err := os.MkdirTemp("", "devlink-bundle-")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		bundleName := strings.TrimSuffix(repo.Name, ".git") + ".bundle"
+		bundlePath := filepath.Join(tmpDir, bundleName)
+		if output != "" {
+			// git runs inside the repository, so resolve relative paths first
+			if bundlePath, err = filepath.Abs(output); err != nil {
+				tmpCleanupFatal(tmpDir, "%v", err)
+			}
+		}
+		if _, err := git(repo.dir, nil, append([]string{"bundle", "create", bundlePath}, revs...)...); err != nil {
+			tmpCleanupFatal(tmpDir, "failed to create bundle: %v", err)
+		}
+		info, err := os.Stat(bundlePath)
+		if err != nil {
+			tmpCleanupFatal(tmpDir, "%v", err)
+		}
+		if output != "" {
+			log.Printf("Bundle written to %s (%d bytes). Import it with:\n\n  devlink git unbundle %s\n", output, info.Size(), output)
+			return
+		}
+
+		root, err := environment.LoadRoot()
+		if err != nil {
+			tmpCleanupFatal(tmpDir, "%v", err)
+		}
+
+		share, err := sdk.CreateShare(root, &sdk.ShareRequest{
+			BackendMode: sdk.TcpTunnelBackendMode,
+			ShareMode:   sdk.PrivateShareMode,
+			Target:      "git-bundle",
+		})
+		if err != nil {
+			tmpCleanupFatal(tmpDir, "%v", err)
+		}
+
+		log.Printf("Bundle of %s ready (%d bytes). Share this command with your teammate:\n\n  devlink git unbundle %s\n", repo.Name, info.Size(), share.Token)
+
+		listener, err := sdk.NewListener(share.Token, root)
+		if err != nil {
+			_ = sdk.DeleteShare(root, share)
+			tmpCleanupFatal(tmpDir, "%v", err)
+		}
+
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-c
+			log.Println("Shutting down git bundle...")
+			_ = listener.Close()
+		}()
+
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				f, err := os.Open(bundlePath)
+				if err != nil {
+					log.Printf("error opening bundle: %v", err)
+					return
+				}
+				defer f.Close()
+
+				header := internal.FrameHeader{Kind: "git-bundle", Name: bundleName, Size: info.Size()}
+				if _, err := internal.WriteFrame(c, header, f); err != nil {
+					log.Printf("error sending bundle: %v", err)
+					return
+				}
+				log.Printf("Bundle sent to a teammate")
+			}(conn)
+		}
+
+		if err := sdk.DeleteShare(root, share); err != nil {
+			log.Printf("error deleting share: %v", err)
+		}
+	},
+}
+
+func init() {
+	gitBundleCmd.Flags().StringP("output", "o", "", "write the bundle to this file instead of sharing it")
+}
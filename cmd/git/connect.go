@@ -1,43 +1,103 @@
 package git
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/devlink-sh/devlink/internal"
 	"github.com/openziti/zrok/environment"
+	"github.com/openziti/zrok/environment/env_core"
 	"github.com/openziti/zrok/sdk/golang/sdk"
 	"github.com/spf13/cobra"
 )
 
-// freeLocalListener finds a free local port by binding to 127.0.0.1:0
-func freeLocalListener() (net.Listener, int, error) {
-	l, err := net.Listen("tcp", "127.0.0.1:0")
+// localListener binds 127.0.0.1:port, or a free local port if port is 0
+func localListener(port int) (net.Listener, int, error) {
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
 	if err != nil {
 		return nil, 0, err
 	}
-	port := l.Addr().(*net.TCPAddr).Port
+	port = l.Addr().(*net.TCPAddr).Port
 	return l, port, nil
 }
 
+// tunnelRemote points a remote of the current repository at the tunnel and
+// remembers its previous URL so it can be restored. Only remotes that are
+// new or already point at a local tunnel are touched, so a real remote such
+// as origin is never overwritten.
+type tunnelRemote struct {
+	name      string
+	url       string
+	authToken string
+	prevURL   string // empty if the remote did not exist
+}
+
+func (t *tunnelRemote) add() error {
+	if _, err := git("", nil, "rev-parse", "--git-dir"); err != nil {
+		return errors.New("--add-remote must be run inside a git repository")
+	}
+	if prev, err := git("", nil, "remote", "get-url", t.name); err == nil {
+		if !strings.HasPrefix(prev, "http://127.0.0.1:") {
+			return fmt.Errorf("remote %s already exists with URL %s; pick another name, e.g. --add-remote peer", t.name, prev)
+		}
+		t.prevURL = prev
+		if _, err := git("", nil, "remote", "set-url", t.name, t.url); err != nil {
+			return err
+		}
+	} else if _, err := git("", nil, "remote", "add", t.name, t.url); err != nil {
+		return err
+	}
+	if t.authToken != "" {
+		// scoped to the tunnel URL so other remotes never see the secret
+		if _, err := git("", nil, "config", "http."+t.url+".extraHeader", "Authorization: Bearer "+t.authToken); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove deletes the remote again, or restores the URL it had before.
+func (t *tunnelRemote) remove() {
+	if t.authToken != "" {
+		_, _ = git("", nil, "config", "--unset-all", "http."+t.url+".extraHeader")
+	}
+	var err error
+	if t.prevURL != "" {
+		_, err = git("", nil, "remote", "set-url", t.name, t.prevURL)
+	} else {
+		_, err = git("", nil, "remote", "remove", t.name)
+	}
+	if err != nil {
+		log.Printf("error removing remote %s: %v", t.name, err)
+	}
+}
+
 var gitConnectCmd = &cobra.Command{
-	Use:   "connect <token> <repo-name.git>",
+	Use:   "connect <token> [repo-name.git]",
 	Short: "Connect to a shared Git repository",
-	Args:  cobra.ExactArgs(2),
+	Long: `Open a local tunnel to a git share. Without a repository name, all
+repositories of the share are listed with their clone URLs.`,
+	Args: cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
 		token := args[0]
-		repoName := args[1]
-
-		// Canonicalize repo name
-		if !strings.HasSuffix(strings.ToLower(repoName), ".git") {
-			repoName += ".git"
+		authToken, _ := cmd.Flags().GetString("auth-token")
+		port, _ := cmd.Flags().GetInt("port")
+		remoteName, _ := cmd.Flags().GetString("add-remote")
+		removeRemote, _ := cmd.Flags().GetBool("remove-remote")
+		if removeRemote && remoteName == "" {
+			log.Fatal("--remove-remote requires --add-remote")
 		}
 
 		// Load zrok environment
@@ -53,31 +113,91 @@ var gitConnectCmd = &cobra.Command{
 		}
 		defer sdk.DeleteAccess(root, session)
 
-		// Bind a free local port for git client to talk to
-		listener, localPort, err := freeLocalListener()
+		var repoNames []string
+		if len(args) == 2 {
+			// Canonicalize repo name
+			repoName := args[1]
+			if !strings.HasSuffix(strings.ToLower(repoName), ".git") {
+				repoName += ".git"
+			}
+			repoNames = append(repoNames, repoName)
+		} else {
+			repos, err := listRepos(token, root, authToken)
+			if err != nil {
+				log.Fatalf("failed to list repositories: %v", err)
+			}
+			for _, repo := range repos {
+				repoNames = append(repoNames, repo.Name)
+			}
+		}
+
+		if remoteName != "" && len(repoNames) != 1 {
+			log.Fatalf("--add-remote needs a repository name; the share has %s", strings.Join(repoNames, ", "))
+		}
+
+		// Bind a local port for git client to talk to (free one unless --port)
+		listener, localPort, err := localListener(port)
 		if err != nil {
 			log.Fatalf("failed to acquire local port: %v", err)
 		}
 		defer listener.Close()
 
 		// Print clone instructions
-		cloneURL := fmt.Sprintf("git://127.0.0.1:%d/%s", localPort, repoName)
-		workDir := strings.TrimSuffix(repoName, ".git")
-		clonePath := filepath.Join(".", workDir)
+		gitCmd := "git"
+		if authToken != "" {
+			gitCmd = fmt.Sprintf("git -c http.extraHeader='Authorization: Bearer %s'", authToken)
+		}
+		var clones strings.Builder
+		for _, repoName := range repoNames {
+			cloneURL := fmt.Sprintf("http://127.0.0.1:%d/%s", localPort, repoName)
+			clonePath := filepath.Join(".", strings.TrimSuffix(repoName, ".git"))
+			fmt.Fprintf(&clones, "  %s clone %s %s\n", gitCmd, cloneURL, clonePath)
+		}
+
+		// Point a remote of the current repository at the tunnel
+		var gitRemote *tunnelRemote
+		if remoteName != "" {
+			gitRemote = &tunnelRemote{
+				name:      remoteName,
+				url:       fmt.Sprintf("http://127.0.0.1:%d/%s", localPort, repoNames[0]),
+				authToken: authToken,
+			}
+			if err := gitRemote.add(); err != nil {
+				log.Fatalf("failed to add remote %s: %v", remoteName, err)
+			}
+		}
 
 		log.Printf("Git tunnel ready!")
-		log.Printf("Clone using:\n\n  git clone %s %s\n", cloneURL, clonePath)
+		if gitRemote != nil {
+			log.Printf("Remote %s now points at the tunnel:\n\n  git fetch %s\n", gitRemote.name, gitRemote.name)
+			if port == 0 {
+				log.Printf("Use --port to keep the remote URL stable across sessions.")
+			}
+		} else {
+			log.Printf("Clone using:\n\n%s", clones.String())
+		}
 		log.Printf("Keep this process running to use git fetch/pull/push.")
 
-		// Handle Ctrl+C
+		// Both the signal handler and the end of the accept loop tear down;
+		// the remote must only be edited once, or the two git config writes
+		// race on .git/config.lock
+		var teardownOnce sync.Once
+		teardown := func() {
+			teardownOnce.Do(func() {
+				_ = listener.Close()
+				if gitRemote != nil && removeRemote {
+					gitRemote.remove()
+				}
+			})
+		}
+
+		// Handle Ctrl+C: closing the listener ends the accept loop below
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 		go func() {
 			<-c
 			log.Println("Shutting down git connect...")
-			_ = listener.Close()
-			_ = sdk.DeleteAccess(root, session)
-			os.Exit(0)
+			teardown()
 		}()
 
 		// Accept loop with backoff for temporary errors
@@ -98,12 +218,14 @@ var gitConnectCmd = &cobra.Command{
 					time.Sleep(tempDelay)
 					continue
 				}
-				log.Printf("fatal accept error: %v", err)
+				if !errors.Is(err, net.ErrClosed) {
+					log.Printf("fatal accept error: %v", err)
+				}
 				break
 			}
 			tempDelay = 0
 
-			// Forward traffic to remote git-daemon through zrok
+			// Forward traffic to the remote git serve session through zrok
 			go func(c net.Conn) {
 				defer c.Close()
 
@@ -115,10 +237,50 @@ var gitConnectCmd = &cobra.Command{
 				}
 				defer remote.Close()
 
-				log.Printf("Forwarding git traffic (client<->remote)...")
 				internal.Pipe(c, remote)
 			}(client)
 
 		}
+		teardown()
 	},
 }
+
+// listRepos asks a git share which repositories it serves.
+func listRepos(token string, root env_core.Root, authToken string) ([]servedRepo, error) {
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return sdk.NewDialer(token, root)
+		},
+	}}
+	req, err := http.NewRequest(http.MethodGet, "http://devlink"+reposPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	if authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+authToken)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("share answered %s", resp.Status)
+	}
+
+	var repos []servedRepo
+	if err := json.NewDecoder(resp.Body).Decode(&repos); err != nil {
+		return nil, err
+	}
+	if len(repos) == 0 {
+		return nil, errors.New("the share has no repositories")
+	}
+	return repos, nil
+}
+
+func init() {
+	gitConnectCmd.Flags().String("auth-token", "", "secret required by the sharer's --auth-token")
+	gitConnectCmd.Flags().Int("port", 0, "local port for the tunnel, so remote URLs stay valid across sessions (default: a free port)")
+	gitConnectCmd.Flags().String("add-remote", "", "add this remote to the current repository, pointing at the tunnel (an existing remote is only updated if it points at a local tunnel)")
+	gitConnectCmd.Flags().Bool("remove-remote", false, "remove the --add-remote remote (or restore its previous URL) on exit")
+}
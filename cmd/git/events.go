@@ -0,0 +1,203 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+// refUpdate is one ref changed by a push.
+type refUpdate struct {
+	Ref      string `json:"ref"`
+	Old      string `json:"old"`
+	New      string `json:"new"`
+	Accepted bool   `json:"accepted"`
+}
+
+// gitEvent describes a fetch or push seen by git serve.
+type gitEvent struct {
+	Time   time.Time   `json:"time"`
+	Type   string      `json:"type"` // "fetch" or "push"
+	Repo   string      `json:"repo"`
+	Client string      `json:"client"`
+	Wants  int         `json:"wants,omitempty"`
+	Refs   []refUpdate `json:"refs,omitempty"`
+}
+
+func (e gitEvent) String() string {
+	switch e.Type {
+	case "fetch":
+		return fmt.Sprintf("fetch %s by %s (%d refs wanted)", e.Repo, e.Client, e.Wants)
+	case "push":
+		var refs []string
+		for _, u := range e.Refs {
+			status := ""
+			if !u.Accepted {
+				status = " (rejected)"
+			}
+			refs = append(refs, fmt.Sprintf("%s %.7s..%.7s%s", u.Ref, u.Old, u.New, status))
+		}
+		return fmt.Sprintf("push %s by %s: %s", e.Repo, e.Client, strings.Join(refs, ", "))
+	}
+	return e.Type + " " + e.Repo
+}
+
+// eventSink logs events and optionally forwards them to the desktop and a
+// webhook.
+type eventSink struct {
+	desktop bool
+	webhook string
+}
+
+func (s *eventSink) emit(e gitEvent) {
+	log.Print(e.String())
+	if s == nil {
+		return
+	}
+	if s.desktop {
+		notifyDesktop("devlink git", e.String())
+	}
+	if s.webhook != "" {
+		go s.post(e)
+	}
+}
+
+func (s *eventSink) post(e gitEvent) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(s.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("webhook error: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("webhook answered %s", resp.Status)
+	}
+}
+
+// notifyDesktop shows a desktop notification, cross-platform
+func notifyDesktop(title, message string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("osascript", "-e",
+			fmt.Sprintf("display notification %s with title %s", strconv.Quote(message), strconv.Quote(title)))
+	case "windows":
+		return
+	default: // Linux, BSD, etc.
+		cmd = exec.Command("notify-send", title, message)
+	}
+	if err := cmd.Start(); err != nil {
+		log.Printf("Failed to show notification: %v", err)
+		return
+	}
+	go cmd.Wait()
+}
+
+// maxPeek bounds how much of a request is buffered to extract event data.
+const maxPeek = 8 << 20
+
+// errPeekLimit means the request was too large to inspect; it is still
+// passed to git, just without an event.
+var errPeekLimit = errors.New("request too large to inspect")
+
+// readPktLines reads pkt-lines from r until a flush packet (or EOF if
+// untilEOF is set) and returns their payloads plus the raw bytes consumed,
+// so the request can be replayed to git unchanged. Delimiter packets are
+// returned as empty strings.
+func readPktLines(r *bufio.Reader, untilEOF bool) ([]string, []byte, error) {
+	var lines []string
+	var raw bytes.Buffer
+	for raw.Len() < maxPeek {
+		head := make([]byte, 4)
+		if _, err := io.ReadFull(r, head); err != nil {
+			if err == io.EOF && untilEOF {
+				return lines, raw.Bytes(), nil
+			}
+			return lines, raw.Bytes(), err
+		}
+		raw.Write(head)
+		n, err := strconv.ParseUint(string(head), 16, 16)
+		if err != nil {
+			return lines, raw.Bytes(), fmt.Errorf("invalid pkt-line length %q", head)
+		}
+		if n == 0 && !untilEOF {
+			return lines, raw.Bytes(), nil
+		}
+		if n < 4 {
+			lines = append(lines, "")
+			continue
+		}
+		payload := make([]byte, n-4)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return lines, raw.Bytes(), err
+		}
+		raw.Write(payload)
+		lines = append(lines, strings.TrimSuffix(string(payload), "\n"))
+	}
+	return lines, raw.Bytes(), errPeekLimit
+}
+
+// inspectUploadPack returns a fetch event for the final request of a fetch
+// (the one containing "done"); ls-refs and negotiation rounds return nil.
+func inspectUploadPack(lines []string) *gitEvent {
+	wants, done := 0, false
+	for _, line := range lines {
+		switch {
+		case line == "command=ls-refs":
+			return nil
+		case strings.HasPrefix(line, "want "):
+			wants++
+		case line == "done":
+			done = true
+		}
+	}
+	if !done {
+		return nil
+	}
+	return &gitEvent{Type: "fetch", Wants: wants}
+}
+
+// inspectReceivePack parses the "<old> <new> <ref>" commands of a push.
+func inspectReceivePack(lines []string) *gitEvent {
+	e := &gitEvent{Type: "push"}
+	for _, line := range lines {
+		line, _, _ = strings.Cut(line, "\x00") // capabilities follow the first command
+		fields := strings.Fields(line)
+		if len(fields) == 3 {
+			e.Refs = append(e.Refs, refUpdate{Old: fields[0], New: fields[1], Ref: fields[2]})
+		}
+	}
+	if len(e.Refs) == 0 {
+		return nil
+	}
+	return e
+}
+
+// checkPushed marks which ref updates actually landed in the repository.
+func checkPushed(dir string, e *gitEvent) {
+	for i, u := range e.Refs {
+		current, err := git(dir, nil, "rev-parse", "-q", "--verify", u.Ref)
+		if u.New == zeroSHA {
+			e.Refs[i].Accepted = err != nil
+		} else {
+			e.Refs[i].Accepted = err == nil && current == u.New
+		}
+	}
+}
@@ -11,4 +11,7 @@ var GitCmd = &cobra.Command{
 func init() {
 	GitCmd.AddCommand(gitServeCmd)
 	GitCmd.AddCommand(gitConnectCmd)
+	GitCmd.AddCommand(gitBundleCmd)
+	GitCmd.AddCommand(gitUnbundleCmd)
+	GitCmd.AddCommand(gitPreReceiveHookCmd)
 }
@@ -0,0 +1,177 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// receiveHooks are the hooks git receive-pack runs. The session hooks
+// directory replaces the repository's for pushes, so each of them is
+// chained to the repository's own hook, found in $DEVLINK_REPO_HOOKS.
+var receiveHooks = []string{"pre-receive", "update", "proc-receive", "post-receive",
+	"post-update", "push-to-checkout", "reference-transaction"}
+
+// installPushHooks writes a session-only hooks directory whose pre-receive
+// hook calls back into devlink to enforce the --allow-push patterns. The
+// directory is activated with core.hooksPath for receive-pack only, and
+// every hook in it then runs the repository's own hook of the same name,
+// so those keep working and are never modified.
+func installPushHooks(allow []string) (string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate devlink binary: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "devlink-hooks-")
+	if err != nil {
+		return "", err
+	}
+
+	check := shellQuote(self) + " git pre-receive-hook"
+	for _, pattern := range allow {
+		check += " --allow " + shellQuote(pattern)
+	}
+
+	for _, name := range receiveHooks {
+		script := "#!/bin/sh\nhook=\"$DEVLINK_REPO_HOOKS/" + name + "\"\n"
+		if name == "pre-receive" {
+			// both hooks read the pushed refs on stdin
+			script += "input=$(cat)\n" +
+				"printf '%s\\n' \"$input\" | " + check + " || exit 1\n" +
+				"if [ -n \"$DEVLINK_REPO_HOOKS\" ] && [ -x \"$hook\" ]; then\n" +
+				"\tprintf '%s\\n' \"$input\" | \"$hook\" \"$@\"\n" +
+				"fi\n"
+		} else {
+			script += "if [ -n \"$DEVLINK_REPO_HOOKS\" ] && [ -x \"$hook\" ]; then\n" +
+				"\texec \"$hook\" \"$@\"\n" +
+				"fi\n"
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			_ = os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// repoHooksDir returns the directory the repository's own hooks run from:
+// core.hooksPath, relative to the working tree (the git directory if
+// bare), or the hooks directory of the git directory.
+func repoHooksDir(repo *servedRepo) string {
+	if path, err := git(repo.dir, nil, "config", "--get", "core.hooksPath"); err == nil && path != "" {
+		if strings.HasPrefix(path, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				path = filepath.Join(home, path[2:])
+			}
+		}
+		if !filepath.IsAbs(path) {
+			base := repo.workTree
+			if base == "" {
+				base = repo.dir
+			}
+			path = filepath.Join(base, path)
+		}
+		return filepath.Clean(path)
+	}
+	return filepath.Join(repo.commonDir, "hooks")
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// refPattern turns a --allow-push pattern into a regexp. Short patterns are
+// relative to refs/heads/, and * matches any characters including '/', as
+// in git refspecs.
+func refPattern(pattern string) (*regexp.Regexp, error) {
+	if !strings.HasPrefix(pattern, "refs/") {
+		pattern = "refs/heads/" + pattern
+	}
+	expr := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	return regexp.Compile("^" + expr + "$")
+}
+
+// checkedOutBranches lists the branches checked out in any worktree.
+func checkedOutBranches() map[string]bool {
+	branches := map[string]bool{}
+	out, err := exec.Command("git", "worktree", "list", "--porcelain").Output()
+	if err != nil {
+		return branches
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if ref, ok := strings.CutPrefix(line, "branch "); ok {
+			branches[ref] = true
+		}
+	}
+	return branches
+}
+
+var gitPreReceiveHookCmd = &cobra.Command{
+	Use:    "pre-receive-hook",
+	Short:  "Enforce git serve push rules (run by git)",
+	Hidden: true,
+	Args:   cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.SetFlags(0)
+		allow, _ := cmd.Flags().GetStringSlice("allow")
+
+		var patterns []*regexp.Regexp
+		for _, a := range allow {
+			re, err := refPattern(a)
+			if err != nil {
+				log.Fatalf("devlink: invalid push pattern %q: %v", a, err)
+			}
+			patterns = append(patterns, re)
+		}
+		checkedOut := checkedOutBranches()
+
+		rejected := false
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			// <old-sha> <new-sha> <ref>
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 3 {
+				continue
+			}
+			ref := fields[2]
+
+			if strings.HasPrefix(ref, "refs/devlink/") {
+				log.Printf("devlink: refusing push to %s: reserved for devlink snapshots", ref)
+				rejected = true
+				continue
+			}
+			if checkedOut[ref] {
+				log.Printf("devlink: refusing push to %s: it is checked out on the sharer's machine", ref)
+				rejected = true
+				continue
+			}
+			if len(patterns) > 0 && !matchAny(patterns, ref) {
+				log.Printf("devlink: refusing push to %s: allowed refs are %s", ref, strings.Join(allow, ", "))
+				rejected = true
+			}
+		}
+		if rejected {
+			os.Exit(1)
+		}
+	},
+}
+
+func matchAny(patterns []*regexp.Regexp, ref string) bool {
+	for _, re := range patterns {
+		if re.MatchString(ref) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	gitPreReceiveHookCmd.Flags().StringSlice("allow", nil, "ref patterns that may be pushed")
+}
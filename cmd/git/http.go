@@ -0,0 +1,205 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// reposPath lists the repositories of a share as JSON.
+const reposPath = "/.devlink/repos"
+
+// smartHTTP serves repositories over git's smart HTTP protocol by running
+// upload-pack/receive-pack in stateless-rpc mode, the same way
+// `git http-backend` does, so no git daemon or export markers are needed.
+// Only the listed repositories are reachable.
+type smartHTTP struct {
+	repos     []*servedRepo
+	push      bool
+	hooksDir  string // session hooks used for pushes, see installPushHooks
+	authToken string // optional shared secret required from clients
+	events    *eventSink
+	children  childSet // running git processes, killed at shutdown
+}
+
+func (s *smartHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.authToken != "" && !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="devlink"`)
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path == "/" || r.URL.Path == reposPath {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.repos)
+		return
+	}
+
+	repo, rest := s.route(r.URL.Path)
+	if repo == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && rest == "/info/refs":
+		s.infoRefs(w, r, repo)
+	case r.Method == http.MethodPost && (rest == "/git-upload-pack" || rest == "/git-receive-pack"):
+		s.rpc(w, r, repo, strings.TrimPrefix(rest, "/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// route finds the repository a request path belongs to and returns the
+// remainder of the path. Clients may drop the .git suffix.
+func (s *smartHTTP) route(path string) (*servedRepo, string) {
+	for _, repo := range s.repos {
+		for _, prefix := range []string{"/" + repo.Name, "/" + strings.TrimSuffix(repo.Name, ".git")} {
+			if rest, ok := strings.CutPrefix(path, prefix); ok && strings.HasPrefix(rest, "/") {
+				return repo, rest
+			}
+		}
+	}
+	return nil, ""
+}
+
+// authorized accepts either "Authorization: Bearer <token>" or basic auth
+// with the token as password.
+func (s *smartHTTP) authorized(r *http.Request) bool {
+	given := ""
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		given = bearer
+	} else if _, password, ok := r.BasicAuth(); ok {
+		given = password
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(s.authToken)) == 1
+}
+
+func (s *smartHTTP) allowed(w http.ResponseWriter, service string) bool {
+	switch service {
+	case "git-upload-pack":
+		return true
+	case "git-receive-pack":
+		if s.push {
+			return true
+		}
+		http.Error(w, "this share is read-only", http.StatusForbidden)
+		return false
+	}
+	http.Error(w, "unsupported service", http.StatusForbidden)
+	return false
+}
+
+func (s *smartHTTP) infoRefs(w http.ResponseWriter, r *http.Request, repo *servedRepo) {
+	service := r.URL.Query().Get("service")
+	if !s.allowed(w, service) {
+		return
+	}
+
+	var out bytes.Buffer
+	cmd := s.command(r, repo, service, "--stateless-rpc", "--advertise-refs")
+	cmd.Stdout = &out
+	if err := s.children.run(cmd); err != nil {
+		log.Printf("error advertising refs for %s: %v", repo.Name, err)
+		http.Error(w, "git error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-"+service+"-advertisement")
+	w.Header().Set("Cache-Control", "no-cache")
+	// protocol v2 responses start directly with the capability list
+	if !(service == "git-upload-pack" && strings.Contains(r.Header.Get("Git-Protocol"), "version=2")) {
+		fmt.Fprintf(w, "%s0000", pktLine("# service="+service+"\n"))
+	}
+	_, _ = w.Write(out.Bytes())
+}
+
+func (s *smartHTTP) rpc(w http.ResponseWriter, r *http.Request, repo *servedRepo, service string) {
+	if !s.allowed(w, service) {
+		return
+	}
+
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, "bad gzip body", http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	// Peek at the request to report who fetched or pushed what; upload-pack
+	// requests are all pkt-lines, receive-pack ones only until the pack data
+	br := bufio.NewReader(body)
+	lines, peeked, err := readPktLines(br, service == "git-upload-pack")
+	if err != nil && err != errPeekLimit {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var event *gitEvent
+	if service == "git-upload-pack" {
+		event = inspectUploadPack(lines)
+	} else {
+		event = inspectReceivePack(lines)
+	}
+
+	w.Header().Set("Content-Type", "application/x-"+service+"-result")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	cmd := s.command(r, repo, service, "--stateless-rpc")
+	cmd.Stdin = io.MultiReader(bytes.NewReader(peeked), br)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	if err := s.children.run(cmd); err != nil {
+		log.Printf("%s for %s failed: %v", service, repo.Name, err)
+		return
+	}
+
+	if event != nil {
+		event.Time = time.Now()
+		event.Repo = repo.Name
+		event.Client = r.RemoteAddr
+		if event.Type == "push" {
+			checkPushed(repo.dir, event)
+		}
+		s.events.emit(*event)
+	}
+}
+
+// command builds `git <service>` for repo with the client's protocol version and,
+// for pushes, the session hooks.
+func (s *smartHTTP) command(r *http.Request, repo *servedRepo, service string, args ...string) *exec.Cmd {
+	var gitArgs []string
+	push := service == "git-receive-pack" && s.hooksDir != ""
+	if push {
+		gitArgs = append(gitArgs, "-c", "core.hooksPath="+s.hooksDir)
+	}
+	gitArgs = append(gitArgs, strings.TrimPrefix(service, "git-"))
+	cmd := exec.Command("git", append(append(gitArgs, args...), repo.dir)...)
+	cmd.Env = os.Environ()
+	if push {
+		// the session hooks chain to the repository's own
+		cmd.Env = append(cmd.Env, "DEVLINK_REPO_HOOKS="+repoHooksDir(repo))
+	}
+	if proto := r.Header.Get("Git-Protocol"); proto != "" {
+		cmd.Env = append(cmd.Env, "GIT_PROTOCOL="+proto)
+	}
+	return cmd
+}
+
+func pktLine(payload string) string {
+	return fmt.Sprintf("%04x%s", len(payload)+4, payload)
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !windows
+
+package git
+
+import "syscall"
+
+// setParentDeathSignal is Linux-only; elsewhere children are stopped by
+// killProcessGroup at shutdown and exit on their own once devlink's pipes close.
+func setParentDeathSignal(attr *syscall.SysProcAttr) {}
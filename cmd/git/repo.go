@@ -0,0 +1,83 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// servedRepo is one repository exposed by git serve.
+type servedRepo struct {
+	Name      string `json:"name"` // URL name, e.g. "project.git"
+	WIP       bool   `json:"wip,omitempty"`
+	dir       string // directory git runs in (per-worktree git dir)
+	commonDir string // git dir shared by all worktrees, holds the refs
+	workTree  string // checkout directory, empty for bare repositories
+}
+
+// resolveRepo locates the repository at repoPath with git rev-parse, so
+// checkouts, their .git directories, bare repositories, linked worktrees
+// (where .git is a file) and submodules are all handled, and derives the
+// name it is served under (<basename>.git).
+//
+// A linked worktree is served with its own HEAD and working tree, so
+// sharing one shares that specific worktree.
+func resolveRepo(repoPath string) (*servedRepo, error) {
+	abs, err := filepath.Abs(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve repo path: %w", err)
+	}
+	if stat, err := os.Stat(abs); err != nil || !stat.IsDir() {
+		return nil, fmt.Errorf("error: %s is not a directory", repoPath)
+	}
+
+	out, err := git(abs, nil, "rev-parse", "--absolute-git-dir", "--git-common-dir",
+		"--is-bare-repository", "--is-inside-work-tree")
+	fields := strings.Split(out, "\n")
+	if err != nil || len(fields) != 4 {
+		return nil, fmt.Errorf("error: %s is not a valid git repository", repoPath)
+	}
+
+	repo := &servedRepo{dir: fields[0], commonDir: fields[1]}
+	if !filepath.IsAbs(repo.commonDir) {
+		repo.commonDir = filepath.Join(abs, repo.commonDir)
+	}
+	repo.commonDir = filepath.Clean(repo.commonDir)
+
+	switch {
+	case fields[3] == "true":
+		if repo.workTree, err = git(abs, nil, "rev-parse", "--show-toplevel"); err != nil {
+			return nil, err
+		}
+	case fields[2] == "false":
+		// repoPath is the git directory of a checkout, e.g. project/.git
+		repo.workTree = workTreeOf(repo.dir)
+	}
+
+	base := repo.workTree
+	if base == "" {
+		base = repo.commonDir
+	}
+	repo.Name = strings.TrimSuffix(filepath.Base(base), ".git") + ".git"
+	return repo, nil
+}
+
+// workTreeOf finds the checkout that uses gitDir, or "" if there is none.
+func workTreeOf(gitDir string) string {
+	// linked worktrees record the path of their .git file
+	if data, err := os.ReadFile(filepath.Join(gitDir, "gitdir")); err == nil {
+		return filepath.Dir(strings.TrimSpace(string(data)))
+	}
+	// submodules and other separated git dirs set core.worktree
+	if wt, err := git(gitDir, nil, "config", "--get", "core.worktree"); err == nil && wt != "" {
+		if !filepath.IsAbs(wt) {
+			wt = filepath.Join(gitDir, wt)
+		}
+		return filepath.Clean(wt)
+	}
+	if filepath.Base(gitDir) == ".git" {
+		return filepath.Dir(gitDir)
+	}
+	return ""
+}
@@ -3,115 +3,173 @@ package git
 import (
 	"fmt"
 	"log"
-	"net"
+	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/devlink-sh/devlink/internal"
 	"github.com/openziti/zrok/environment"
 	"github.com/openziti/zrok/sdk/golang/sdk"
 	"github.com/spf13/cobra"
 )
 
-func getFreePort() (int, error) {
-	l, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		return 0, err
-	}
-	defer l.Close()
-	return l.Addr().(*net.TCPAddr).Port, nil
-}
-
-func canonicalRepoName(repoPath string) (string, string, error) {
-	abs, err := filepath.Abs(repoPath)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to resolve repo path: %w", err)
-	}
-
-	// If user gave ".", normalize to repo root using `git rev-parse --show-toplevel`
-	cmdCheck := exec.Command("git", "rev-parse", "--show-toplevel")
-	cmdCheck.Dir = abs
-	if out, err := cmdCheck.Output(); err == nil {
-		abs = strings.TrimSpace(string(out))
-	}
-
-	// If it's a working repo, redirect to .git folder
-	gitDir := filepath.Join(abs, ".git")
-	if stat, err := os.Stat(gitDir); err == nil && stat.IsDir() {
-		abs = gitDir
-	}
-
-	// Validate repo (must contain HEAD)
-	if _, err := os.Stat(filepath.Join(abs, "HEAD")); err != nil {
-		return "", "", fmt.Errorf("error: %s is not a valid git repository", repoPath)
+// cleanupSessions implements --cleanup: it removes session leftovers, even
+// if the recorded process still seems alive. A git-daemon-export-ok marker
+// is only reported: older devlink versions created one without recording
+// it, so it may just as well belong to the user's own git daemon.
+func cleanupSessions(repoPaths []string) {
+	for _, repoPath := range repoPaths {
+		repo, err := resolveRepo(repoPath)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if err := recoverSession(repo.dir, true); err != nil {
+			log.Fatalf("%v", err)
+		}
+		exportOk := filepath.Join(repo.commonDir, "git-daemon-export-ok")
+		if _, err := os.Stat(exportOk); err == nil {
+			log.Printf("Leaving %s in place; delete it if an older devlink created it and you do not run git daemon", exportOk)
+		}
+		log.Printf("%s is clean", repo.Name)
 	}
-
-	// Derive canonical repo name <basename>.git and parent dir used as base-path for git-daemon
-	repoRoot := filepath.Dir(abs) // abs points to .../.git
-	repoName := filepath.Base(repoRoot) + ".git"
-	parentDir := filepath.Dir(repoRoot)
-	return repoName, parentDir, nil
 }
 
 var gitServeCmd = &cobra.Command{
-	Use:   "serve <repo-path>",
-	Short: "Share a local Git repository",
-	Args:  cobra.ExactArgs(1),
+	Use:   "serve <repo-path>...",
+	Short: "Share local Git repositories",
+	Long: `Share one or more local Git repositories over git's smart HTTP protocol.
+Only the given repositories are exposed, and no git daemon is required.
+Example: devlink git serve . ../shared-lib ../proto`,
+	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		repoPath := args[0]
+		readOnly, _ := cmd.Flags().GetBool("read-only")
+		allowPush, _ := cmd.Flags().GetStringSlice("allow-push")
+		authToken, _ := cmd.Flags().GetString("auth-token")
+		includeWorktree, _ := cmd.Flags().GetBool("include-worktree")
+		wipInterval, _ := cmd.Flags().GetDuration("wip-interval")
+		notify, _ := cmd.Flags().GetBool("notify")
+		webhook, _ := cmd.Flags().GetString("webhook")
+		if cleanupOnly, _ := cmd.Flags().GetBool("cleanup"); cleanupOnly {
+			cleanupSessions(args)
+			return
+		}
+		if len(allowPush) > 0 {
+			readOnly = false
+		}
+		for _, pattern := range allowPush {
+			if _, err := refPattern(pattern); err != nil {
+				log.Fatalf("invalid --allow-push pattern %q: %v", pattern, err)
+			}
+		}
 
-		repoName, parentDir, err := canonicalRepoName(repoPath)
-		if err != nil {
-			log.Fatalf("%v", err)
+		handler := &smartHTTP{
+			push:      !readOnly,
+			authToken: authToken,
+			events:    &eventSink{desktop: notify, webhook: webhook},
 		}
+		seen := map[string]string{}
+		var names []string
+		for _, repoPath := range args {
+			repo, err := resolveRepo(repoPath)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			if other, ok := seen[repo.Name]; ok {
+				log.Fatalf("%s and %s would both be served as %s", other, repoPath, repo.Name)
+			}
+			if err := recoverSession(repo.dir, false); err != nil {
+				log.Fatalf("%v", err)
+			}
+			seen[repo.Name] = repoPath
+			names = append(names, repo.Name)
+			handler.repos = append(handler.repos, repo)
+		}
+		repoList := strings.Join(names, ", ")
 
-		// Ensure repo exportability (create temporarily; delete on shutdown if we created it)
-		repoGitDir := filepath.Join(parentDir, strings.TrimSuffix(repoName, ".git"), ".git")
-		exportOk := filepath.Join(repoGitDir, "git-daemon-export-ok")
-		exportCreated := false
-		if _, err := os.Stat(exportOk); os.IsNotExist(err) {
-			if f, err := os.Create(exportOk); err == nil {
-				_ = f.Close()
-				exportCreated = true
-			} else {
-				log.Fatalf("failed to create git-daemon-export-ok: %v", err)
+		// Pushes go through a session pre-receive hook that enforces the
+		// allowed refs and protects checked-out branches
+		var err error
+		if !readOnly {
+			handler.hooksDir, err = installPushHooks(allowPush)
+			if err != nil {
+				log.Fatalf("failed to install push hooks: %v", err)
 			}
 		}
 
-		// Pick a free local port for git-daemon
-		gitPort, err := getFreePort()
-		if err != nil {
-			log.Fatalf("failed to allocate port for git daemon: %v", err)
+		// Snapshot uncommitted work into refs/devlink/wip and keep it fresh
+		var snapshots []*wipSnapshot
+		stopSnapshots := make(chan struct{})
+		if includeWorktree {
+			// the snapshot refs are shared by all worktrees of a repository
+			snapshotted := map[string]string{}
+			for _, repo := range handler.repos {
+				if repo.workTree == "" {
+					log.Printf("%s has no working tree, skipping --include-worktree", repo.Name)
+					continue
+				}
+				if other, ok := snapshotted[repo.commonDir]; ok {
+					log.Printf("%s shares its refs with %s, skipping --include-worktree", repo.Name, other)
+					continue
+				}
+				snapshotted[repo.commonDir] = repo.Name
+				snap := &wipSnapshot{repo: repo}
+				if _, err := snap.update(); err != nil {
+					for _, s := range snapshots {
+						s.remove()
+					}
+					if handler.hooksDir != "" {
+						_ = os.RemoveAll(handler.hooksDir)
+					}
+					log.Fatalf("failed to snapshot %s: %v", repo.Name, err)
+				}
+				repo.WIP = true
+				snapshots = append(snapshots, snap)
+				go snap.watch(wipInterval, stopSnapshots)
+			}
 		}
 
-		// Start git daemon bound to loopback and chosen port
-		gitDaemon := exec.Command("git", "daemon",
-			"--reuseaddr",
-			"--listen=127.0.0.1",
-			fmt.Sprintf("--port=%d", gitPort),
-			"--base-path="+parentDir,
-			"--export-all",
-			"--verbose",
-			"--enable=upload-pack",  // allow clone/pull
-			"--enable=receive-pack", // allow push
-		)
-		gitDaemon.Stdout = os.Stdout
-		gitDaemon.Stderr = os.Stderr
+		// Record the session so a crashed run can be cleaned up later
+		lock := sessionLock{PID: os.Getpid(), Started: time.Now(), HooksDir: handler.hooksDir}
+		for _, repo := range handler.repos {
+			repoLock := lock
+			if repo.WIP {
+				repoLock.Refs = []string{wipRef, indexRef}
+			}
+			if err := writeSessionLock(repo.dir, repoLock); err != nil {
+				log.Printf("failed to write session lock for %s: %v", repo.Name, err)
+			}
+		}
+
+		cleanup := func() {
+			handler.children.killAll()
+			if handler.hooksDir != "" {
+				_ = os.RemoveAll(handler.hooksDir)
+			}
+			close(stopSnapshots)
+			for _, snap := range snapshots {
+				snap.remove()
+			}
+			for _, repo := range handler.repos {
+				removeSessionLock(repo.dir)
+			}
+		}
 
-		if err := gitDaemon.Start(); err != nil {
-			log.Fatalf("failed to start git daemon: %v", err)
+		switch {
+		case readOnly:
+			log.Printf("Sharing %s read-only; use --allow-push <pattern> to accept pushes", repoList)
+		case len(allowPush) > 0:
+			log.Printf("Sharing %s, accepting pushes to %s (never to checked-out branches)", repoList, strings.Join(allowPush, ", "))
+		default:
+			log.Printf("Sharing %s, accepting pushes to any branch that is not checked out", repoList)
 		}
-		log.Printf("git daemon started for %s (127.0.0.1:%d)", repoName, gitPort)
 
 		// Setup zrok share (directional: this side is the server)
 		root, err := environment.LoadRoot()
 		if err != nil {
-			_ = gitDaemon.Process.Kill()
+			cleanup()
 			log.Fatal(err)
 		}
 
@@ -121,77 +179,64 @@ var gitServeCmd = &cobra.Command{
 			Target:      "git",
 		})
 		if err != nil {
-			_ = gitDaemon.Process.Kill()
+			cleanup()
 			log.Fatal(err)
 		}
+		remoteHost := share.Token
+		if authToken != "" {
+			remoteHost = authToken + "@" + share.Token
+		}
+		var clones strings.Builder
+		for _, name := range names {
+			fmt.Fprintf(&clones, "  git clone devlink://%s/%s\n", remoteHost, name)
+		}
 		log.Printf("Git share ready!")
-		log.Printf("Share this command with your teammate:\n\n  devlink git connect %s %s\n", share.Token, repoName)
+		if len(names) == 1 {
+			log.Printf("Share this command with your teammate:\n\n  devlink git connect %s %s\n", share.Token, names[0])
+		} else {
+			log.Printf("Share this command with your teammate to list the repositories:\n\n  devlink git connect %s\n", share.Token)
+		}
+		log.Printf("Or, with git-remote-devlink installed, clone directly:\n\n%s", clones.String())
+		if len(snapshots) > 0 {
+			log.Printf("Uncommitted work is available to teammates with:\n\n  git fetch <remote> %s && git checkout FETCH_HEAD\n", wipRef)
+		}
 
-		// Accept zrok connections and forward to local git-daemon
+		// Serve smart HTTP directly on the zrok listener
 		listener, err := sdk.NewListener(share.Token, root)
 		if err != nil {
 			_ = sdk.DeleteShare(root, share)
-			_ = gitDaemon.Process.Kill()
+			cleanup()
 			log.Fatal(err)
 		}
+		server := &http.Server{Handler: handler}
 
 		// Graceful shutdown
 		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 		go func() {
 			<-c
 			log.Println("Shutting down git serve...")
-			if exportCreated {
-				_ = os.Remove(exportOk)
-			}
-			_ = listener.Close()
-			_ = sdk.DeleteShare(root, share)
-			_ = gitDaemon.Process.Kill()
-			os.Exit(0)
+			_ = server.Close()
 		}()
 
-		// Accept loop with backoff on temporary errors
-		var tempDelay time.Duration
-		for {
-			remote, err := listener.Accept()
-			if err != nil {
-				if ne, ok := err.(interface{ Temporary() bool }); ok && ne.Temporary() {
-					if tempDelay == 0 {
-						tempDelay = 50 * time.Millisecond
-					} else {
-						tempDelay *= 2
-						if tempDelay > time.Second {
-							tempDelay = time.Second
-						}
-					}
-					log.Printf("temporary accept error: %v; retrying in %v", err, tempDelay)
-					time.Sleep(tempDelay)
-					continue
-				}
-				// Permanent error
-				log.Printf("fatal accept error: %v", err)
-				break
-			}
-			tempDelay = 0
-
-			go func(remoteConn net.Conn) {
-				local, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", gitPort))
-				if err != nil {
-					log.Printf("error dialing local git daemon: %v", err)
-					_ = remoteConn.Close()
-					return
-				}
-				log.Println("Forwarding git traffic (remote<->local)...")
-				internal.Pipe(remoteConn, local)
-			}(remote)
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("error serving git: %v", err)
 		}
 
-		// Cleanup on loop exit
-		if exportCreated {
-			_ = os.Remove(exportOk)
-		}
+		// Cleanup on exit
+		cleanup()
 		_ = listener.Close()
 		_ = sdk.DeleteShare(root, share)
-		_ = gitDaemon.Process.Kill()
 	},
 }
+
+func init() {
+	gitServeCmd.Flags().Bool("read-only", true, "only allow clone and fetch (set --read-only=false to accept pushes)")
+	gitServeCmd.Flags().StringSlice("allow-push", nil, "accept pushes to refs matching these patterns, e.g. 'feature/*' (implies --read-only=false)")
+	gitServeCmd.Flags().Bool("include-worktree", false, "also share uncommitted work as "+wipRef+" (re-snapshotted every --wip-interval while serving)")
+	gitServeCmd.Flags().Duration("wip-interval", 2*time.Second, "how often to poll the working tree for the --include-worktree snapshot")
+	gitServeCmd.Flags().Bool("notify", false, "show a desktop notification for every fetch and push")
+	gitServeCmd.Flags().String("webhook", "", "POST every fetch and push as JSON to this URL")
+	gitServeCmd.Flags().String("auth-token", "", "require this secret from clients (sent as an Authorization header)")
+	gitServeCmd.Flags().Bool("cleanup", false, "remove leftovers of crashed git serve sessions from the given repositories and exit")
+}
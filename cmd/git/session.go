@@ -0,0 +1,112 @@
+package git
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// sessionLock records what a git serve session changed in a repository, so
+// that leftovers of a crashed session (or kill -9) can be cleaned up by the
+// next session or by `git serve --cleanup`.
+type sessionLock struct {
+	PID      int       `json:"pid"`
+	Started  time.Time `json:"started"`
+	HooksDir string    `json:"hooks_dir,omitempty"`
+	Refs     []string  `json:"refs,omitempty"`
+}
+
+const sessionLockName = "devlink-serve.lock"
+
+func lockPath(gitDir string) string {
+	return filepath.Join(gitDir, sessionLockName)
+}
+
+func writeSessionLock(gitDir string, lock sessionLock) error {
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(lockPath(gitDir), append(data, '\n'), 0644)
+}
+
+func removeSessionLock(gitDir string) {
+	_ = os.Remove(lockPath(gitDir))
+}
+
+// recoverSession makes sure no other live session serves gitDir and cleans
+// up after a previous session that did not exit cleanly. With force the
+// recorded process is assumed dead, for when its pid has been reused.
+func recoverSession(gitDir string, force bool) error {
+	data, err := os.ReadFile(lockPath(gitDir))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var lock sessionLock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		log.Printf("ignoring corrupt %s: %v", lockPath(gitDir), err)
+		removeSessionLock(gitDir)
+		return nil
+	}
+	if !force && lock.PID != os.Getpid() && processAlive(lock.PID) {
+		return fmt.Errorf("%s is already being served by devlink (pid %d); stop it first or run 'devlink git serve --cleanup' if that process is not devlink", gitDir, lock.PID)
+	}
+
+	log.Printf("Cleaning up after a git serve session that did not exit cleanly (pid %d, started %s)",
+		lock.PID, lock.Started.Format(time.RFC3339))
+	if lock.HooksDir != "" {
+		_ = os.RemoveAll(lock.HooksDir)
+	}
+	for _, ref := range lock.Refs {
+		if _, err := git(gitDir, nil, "update-ref", "-d", ref); err != nil {
+			log.Printf("error removing %s: %v", ref, err)
+		}
+	}
+	removeSessionLock(gitDir)
+	return nil
+}
+
+// childSet tracks running git processes so they can be killed, together
+// with their process groups, when the session ends.
+type childSet struct {
+	mu   sync.Mutex
+	cmds map[*exec.Cmd]struct{}
+}
+
+// run starts cmd in its own process group and waits for it.
+func (c *childSet) run(cmd *exec.Cmd) error {
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if c.cmds == nil {
+		c.cmds = map[*exec.Cmd]struct{}{}
+	}
+	c.cmds[cmd] = struct{}{}
+	c.mu.Unlock()
+
+	err := cmd.Wait()
+
+	c.mu.Lock()
+	delete(c.cmds, cmd)
+	c.mu.Unlock()
+	return err
+}
+
+func (c *childSet) killAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for cmd := range c.cmds {
+		killProcessGroup(cmd)
+	}
+}
@@ -0,0 +1,114 @@
+package git
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/devlink-sh/devlink/internal"
+	"github.com/openziti/zrok/environment"
+	"github.com/openziti/zrok/sdk/golang/sdk"
+	"github.com/spf13/cobra"
+)
+
+var gitUnbundleCmd = &cobra.Command{
+	Use:   "unbundle <token|file.bundle>",
+	Short: "Fetch a shared git bundle into a local repository",
+	Long: `Receive a bundle shared with 'devlink git bundle' (or read a local .bundle
+file), verify it and fetch its branches into refs/remotes/<remote>/ of the
+current repository. Outside a repository the bundle is cloned instead.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		source := args[0]
+		repoDir, _ := cmd.Flags().GetString("repo")
+		remote, _ := cmd.Flags().GetString("remote")
+
+		tmpDir, err := os.MkdirTemp("", "devlink-unbundle-")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		bundlePath := source
+		if _, err := os.Stat(source); err != nil {
+			if bundlePath, err = receiveBundle(source, tmpDir); err != nil {
+				tmpCleanupFatal(tmpDir, "failed to receive bundle: %v", err)
+			}
+		}
+		if bundlePath, err = filepath.Abs(bundlePath); err != nil {
+			tmpCleanupFatal(tmpDir, "%v", err)
+		}
+
+		// Outside a repository there is nothing to verify against: clone
+		if _, err := git(repoDir, nil, "rev-parse", "--git-dir"); err != nil {
+			dest := filepath.Join(repoDir, strings.TrimSuffix(filepath.Base(bundlePath), ".bundle"))
+			if _, err := git(repoDir, nil, "clone", bundlePath, dest); err != nil {
+				tmpCleanupFatal(tmpDir, "failed to clone bundle: %v", err)
+			}
+			log.Printf("Cloned bundle into %s", dest)
+			return
+		}
+
+		if _, err := git(repoDir, nil, "bundle", "verify", bundlePath); err != nil {
+			tmpCleanupFatal(tmpDir, "bundle cannot be applied to this repository: %v", err)
+		}
+		if _, err := git(repoDir, nil, "fetch", bundlePath,
+			"+refs/heads/*:refs/remotes/"+remote+"/*", "refs/tags/*:refs/tags/*"); err != nil {
+			tmpCleanupFatal(tmpDir, "failed to fetch bundle: %v", err)
+		}
+
+		heads, _ := git(repoDir, nil, "bundle", "list-heads", bundlePath)
+		log.Printf("Fetched bundle into refs/remotes/%s/:\n%s", remote, heads)
+	},
+}
+
+// receiveBundle downloads a shared bundle into dir and returns its path.
+func receiveBundle(token, dir string) (string, error) {
+	root, err := environment.LoadRoot()
+	if err != nil {
+		return "", err
+	}
+
+	acc, err := sdk.CreateAccess(root, &sdk.AccessRequest{ShareToken: token})
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := sdk.DeleteAccess(root, acc); err != nil {
+			log.Printf("error deleting access: %v", err)
+		}
+	}()
+
+	conn, err := sdk.NewDialer(token, root)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	tmp := filepath.Join(dir, "received.bundle")
+	frame, n, err := internal.SaveFrame(conn, tmp)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Received %s (%d bytes, sha256 %s)", frame.Header.Name, n, frame.Sum())
+
+	// keep the sharer's file name so a clone gets a sensible directory
+	path := filepath.Join(dir, filepath.Base(frame.Header.Name))
+	if !strings.HasSuffix(path, ".bundle") || os.Rename(tmp, path) != nil {
+		return tmp, nil
+	}
+	return path, nil
+}
+
+// tmpCleanupFatal removes the temporary directory before exiting, since
+// log.Fatal skips deferred calls.
+func tmpCleanupFatal(dir, format string, args ...interface{}) {
+	_ = os.RemoveAll(dir)
+	log.Fatalf(format, args...)
+}
+
+func init() {
+	gitUnbundleCmd.Flags().String("repo", ".", "repository to fetch into")
+	gitUnbundleCmd.Flags().String("remote", "devlink", "fetch branches into refs/remotes/<remote>/")
+}
@@ -0,0 +1,156 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	wipRef   = "refs/devlink/wip"   // working tree snapshot, parents HEAD and index
+	indexRef = "refs/devlink/index" // staged changes snapshot, parent HEAD
+)
+
+// snapshotIdentity is used for snapshot commits so they work even when the
+// user has no git identity configured.
+var snapshotIdentity = []string{
+	"GIT_AUTHOR_NAME=devlink", "GIT_AUTHOR_EMAIL=devlink@localhost",
+	"GIT_COMMITTER_NAME=devlink", "GIT_COMMITTER_EMAIL=devlink@localhost",
+}
+
+// git runs a git command in dir and returns its trimmed stdout.
+func git(dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// wipSnapshot records uncommitted work of a repository under refs/devlink/
+// using plumbing commands and temporary index files, so the user's branches,
+// HEAD and index are never modified.
+type wipSnapshot struct {
+	repo     *servedRepo
+	lastTree string
+}
+
+// update snapshots the index and working tree and moves the refs if
+// anything changed. It returns the new wip commit, or "" if unchanged.
+func (w *wipSnapshot) update() (string, error) {
+	gitDir, workTree := w.repo.dir, w.repo.workTree
+
+	indexTree, err := w.writeTree(filepath.Join(gitDir, "index"), false)
+	if err != nil {
+		return "", err
+	}
+	workTreeTree, err := w.writeTree(filepath.Join(gitDir, "index"), true)
+	if err != nil {
+		return "", err
+	}
+	if indexTree+workTreeTree == w.lastTree {
+		return "", nil
+	}
+
+	var parents []string
+	if head, err := git(workTree, nil, "rev-parse", "-q", "--verify", "HEAD^{commit}"); err == nil && head != "" {
+		parents = append(parents, "-p", head)
+	}
+	indexCommit, err := git(workTree, snapshotIdentity, append([]string{"commit-tree", indexTree, "-m", "devlink: staged changes"}, parents...)...)
+	if err != nil {
+		return "", err
+	}
+	wipCommit, err := git(workTree, snapshotIdentity, append(append([]string{"commit-tree", workTreeTree, "-m", "devlink: work in progress"}, parents...), "-p", indexCommit)...)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := git(workTree, nil, "update-ref", "-m", "devlink snapshot", indexRef, indexCommit); err != nil {
+		return "", err
+	}
+	if _, err := git(workTree, nil, "update-ref", "-m", "devlink snapshot", wipRef, wipCommit); err != nil {
+		return "", err
+	}
+	w.lastTree = indexTree + workTreeTree
+	return wipCommit, nil
+}
+
+// writeTree writes a tree from a private copy of the index, optionally after
+// adding all working tree changes (respecting .gitignore) to that copy.
+func (w *wipSnapshot) writeTree(index string, addAll bool) (string, error) {
+	tmp, err := os.CreateTemp("", "devlink-index-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	src, err := os.Open(index)
+	if err == nil {
+		_, err = io.Copy(tmp, src)
+		src.Close()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// nothing staged yet: git starts from an empty index when the file
+		// is missing, but refuses an empty one
+		if err := os.Remove(tmp.Name()); err != nil {
+			return "", err
+		}
+	case err != nil:
+		return "", err
+	}
+
+	env := []string{"GIT_INDEX_FILE=" + tmp.Name()}
+	if addAll {
+		if _, err := git(w.repo.workTree, env, "add", "-A"); err != nil {
+			return "", err
+		}
+	}
+	return git(w.repo.workTree, env, "write-tree")
+}
+
+// watch polls the working tree every interval, refreshing the snapshot until
+// stop is closed. Each poll stages the tree into a temporary index, which is
+// cheap for typical repositories; use a longer interval for huge ones.
+func (w *wipSnapshot) watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			commit, err := w.update()
+			if err != nil {
+				log.Printf("error snapshotting %s: %v", w.repo.Name, err)
+			} else if commit != "" {
+				log.Printf("Updated %s of %s (%.7s)", wipRef, w.repo.Name, commit)
+			}
+		}
+	}
+}
+
+// remove deletes the snapshot refs.
+func (w *wipSnapshot) remove() {
+	for _, ref := range []string{wipRef, indexRef} {
+		if _, err := git(w.repo.workTree, nil, "update-ref", "-d", ref); err != nil {
+			log.Printf("error removing %s: %v", ref, err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestWipSnapshotEmptyRepo(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	if _, err := git(dir, nil, "init", "-q"); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := resolveRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(repo.dir, "index")); !os.IsNotExist(err) {
+		t.Fatalf("fresh repository has an index: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &wipSnapshot{repo: repo}
+	commit, err := w.update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit == "" {
+		t.Fatal("no snapshot of the working tree")
+	}
+	got, err := git(dir, nil, "show", wipRef+":hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "hello" {
+		t.Fatalf("snapshot has %q, want %q", got, "hello")
+	}
+	if _, err := os.Stat(filepath.Join(repo.dir, "index")); !os.IsNotExist(err) {
+		t.Fatalf("snapshot touched the repository index: %v", err)
+	}
+}
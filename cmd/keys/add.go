@@ -0,0 +1,33 @@
+package keys
+
+import (
+	"log"
+
+	"github.com/devlink-sh/devlink/internal"
+	"github.com/spf13/cobra"
+)
+
+var keysAddCmd = &cobra.Command{
+	Use:   "add <name> <pubkey>",
+	Short: "Add or update a teammate's public key",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		name, encoded := args[0], args[1]
+
+		pub, err := internal.ParsePublicKey(encoded)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		contacts, err := internal.LoadContacts()
+		if err != nil {
+			log.Fatal(err)
+		}
+		contacts[name] = internal.EncodePublicKey(pub)
+		if err := internal.SaveContacts(contacts); err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("Added %s (%s). Share secrets with them using --to %s", name, internal.Fingerprint(pub), name)
+	},
+}
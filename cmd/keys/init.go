@@ -0,0 +1,30 @@
+package keys
+
+import (
+	"log"
+
+	"github.com/devlink-sh/devlink/internal"
+	"github.com/spf13/cobra"
+)
+
+var keysInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Generate your local keypair",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		force, _ := cmd.Flags().GetBool("force")
+
+		priv, err := internal.GenerateKey(force)
+		if err != nil {
+			log.Fatalf("%v (use --force to replace it)", err)
+		}
+
+		pub := priv.PublicKey()
+		log.Printf("Keypair created (%s)! Send your public key to teammates so they can run:\n\n  devlink keys add <your-name> %s\n",
+			internal.Fingerprint(pub), internal.EncodePublicKey(pub))
+	},
+}
+
+func init() {
+	keysInitCmd.Flags().Bool("force", false, "replace an existing keypair")
+}
@@ -0,0 +1,15 @@
+package keys
+
+import "github.com/spf13/cobra"
+
+var KeysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage keys for recipient-pinned shares",
+	Long:  `Manage your local keypair and the public keys of teammates you share secrets with.`,
+}
+
+func init() {
+	KeysCmd.AddCommand(keysInitCmd)
+	KeysCmd.AddCommand(keysAddCmd)
+	KeysCmd.AddCommand(keysListCmd)
+}
@@ -0,0 +1,41 @@
+package keys
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/devlink-sh/devlink/internal"
+	"github.com/spf13/cobra"
+)
+
+var keysListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Show your public key and known contacts",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if priv, err := internal.LoadKey(); err == nil {
+			fmt.Printf("you\t%s\t%s\n", internal.Fingerprint(priv.PublicKey()), internal.EncodePublicKey(priv.PublicKey()))
+		} else {
+			log.Printf("%v", err)
+		}
+
+		contacts, err := internal.LoadContacts()
+		if err != nil {
+			log.Fatal(err)
+		}
+		names := make([]string, 0, len(contacts))
+		for name := range contacts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			pub, err := internal.ParsePublicKey(contacts[name])
+			if err != nil {
+				log.Printf("%s: %v", name, err)
+				continue
+			}
+			fmt.Printf("%s\t%s\t%s\n", name, internal.Fingerprint(pub), contacts[name])
+		}
+	},
+}
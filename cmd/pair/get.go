@@ -4,19 +4,26 @@ import (
 	"io"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/devlink-sh/devlink/internal"
 	"github.com/openziti/zrok/environment"
 	"github.com/openziti/zrok/sdk/golang/sdk"
 	"github.com/spf13/cobra"
 )
 
 var pairGetCmd = &cobra.Command{
-	Use:   "get <token> <port>",
+	Use:   "get <token> <port|unix:///path>",
 	Short: "Connect to a shared frontend",
 	Args:  cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
 		token := args[0]
-		port := args[1]
+		local, err := internal.ParseEndpoint(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
 
 		root, err := environment.LoadRoot()
 		if err != nil {
@@ -29,13 +36,27 @@ var pairGetCmd = &cobra.Command{
 		}
 		defer sdk.DeleteAccess(root, acc)
 
-		listener, err := net.Listen("tcp", "127.0.0.1:"+port)
+		listener, err := local.Listen()
 		if err != nil {
 			log.Fatal(err)
 		}
 		defer listener.Close()
 
-		log.Printf("Frontend available locally at http://127.0.0.1:%s", port)
+		if local.IsUnix() {
+			log.Printf("Frontend available locally at %s, e.g. curl --unix-socket %s http://localhost/", local, local.Address)
+		} else {
+			log.Printf("Frontend available locally at http://%s", local)
+		}
+
+		// Close the listener on exit so a Unix socket file is removed
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-c
+			_ = listener.Close()
+			_ = sdk.DeleteAccess(root, acc)
+			os.Exit(0)
+		}()
 
 		for {
 			client, err := listener.Accept()
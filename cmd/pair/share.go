@@ -5,16 +5,20 @@ import (
 	"log"
 	"net"
 
+	"github.com/devlink-sh/devlink/internal"
 	"github.com/openziti/zrok/environment"
 	"github.com/openziti/zrok/sdk/golang/sdk"
 	"github.com/spf13/cobra"
 )
 
 var pairShareCmd = &cobra.Command{
-	Use:  "share <port>",
+	Use:  "share <port|unix:///path>",
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		port := args[0]
+		backend, err := internal.ParseEndpoint(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
 
 		root, err := environment.LoadRoot()
 		if err != nil {
@@ -46,13 +50,13 @@ var pairShareCmd = &cobra.Command{
 			}
 
 			go func(remote net.Conn) {
-				local, err := net.Dial("tcp", "127.0.0.1:"+port)
+				local, err := backend.Dial()
 				if err != nil {
 					log.Printf("error connecting to local service: %v", err)
 					_ = remote.Close()
 					return
 				}
-				log.Printf("Forwarding client -> localhost:%s", port)
+				log.Printf("Forwarding client -> %s", backend)
 				Pipe(remote, local)
 			}(conn)
 		}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 
+	"github.com/devlink-sh/devlink/internal"
 	"github.com/openziti/zrok/environment"
 	"github.com/openziti/zrok/sdk/golang/sdk"
 	"github.com/spf13/cobra"
@@ -58,11 +59,20 @@ var registryGetCmd = &cobra.Command{
 			log.Fatalf("error starting docker load: %v", err)
 		}
 
-		// conn -> docker load stdin
-		_, err = io.Copy(stdin, conn)
+		// conn -> docker load stdin; stdin is only closed once the checksum
+		// matched, so a truncated image never reaches docker
+		frame, err := internal.NewFrameReader(conn)
 		if err != nil {
-			log.Fatalf("error streaming image into docker load: %v", err)
+			_ = loadCmd.Process.Kill()
+			log.Fatalf("error receiving image: %v", err)
 		}
+		n, err := io.Copy(stdin, frame)
+		if err != nil {
+			_ = loadCmd.Process.Kill()
+			_ = loadCmd.Wait()
+			log.Fatalf("error streaming image into docker load after %d bytes: %v", n, err)
+		}
+		log.Printf("Received %s (%d bytes, sha256 %s)", frame.Header.Name, n, frame.Sum())
 
 		// close stdin to signal docker load EOF
 		_ = stdin.Close()
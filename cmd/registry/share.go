@@ -1,6 +1,7 @@
 package registry
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -8,6 +9,7 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/devlink-sh/devlink/internal"
 	"github.com/openziti/zrok/environment"
 	"github.com/openziti/zrok/sdk/golang/sdk"
 	"github.com/spf13/cobra"
@@ -81,19 +83,36 @@ var registryShareCmd = &cobra.Command{
 					return
 				}
 
-				// stream docker save stdout -> connection
-				_, err = io.Copy(c, stdout)
+				// stream docker save stdout -> connection; the checksum trailer is
+				// only sent if docker save succeeds, so failures can't look complete
+				header := internal.FrameHeader{Kind: "docker-image", Name: image, Size: -1}
+				n, err := internal.WriteFrame(c, header, &cmdReader{r: stdout, cmd: saveCmd})
 				if err != nil {
 					log.Printf("streaming error: %v", err)
+					_ = saveCmd.Process.Kill()
+					_ = saveCmd.Wait() // reap it; a no-op if cmdReader already did
+					return
 				}
 
-				// wait for docker save to finish
-				if err := saveCmd.Wait(); err != nil {
-					log.Printf("docker save exited with error: %v", err)
-				}
-
-				log.Printf("finished streaming image %s to client", image)
+				log.Printf("finished streaming image %s to client (%d bytes)", image, n)
 			}(conn)
 		}
 	},
 }
+
+// cmdReader reads a command's stdout and, at EOF, reports the command's exit
+// status instead of a clean EOF if it failed.
+type cmdReader struct {
+	r   io.Reader
+	cmd *exec.Cmd
+}
+
+func (cr *cmdReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if err == io.EOF {
+		if werr := cr.cmd.Wait(); werr != nil {
+			return n, fmt.Errorf("docker save exited with error: %w", werr)
+		}
+	}
+	return n, err
+}
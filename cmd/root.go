@@ -9,6 +9,7 @@ import (
 	"github.com/devlink-sh/devlink/cmd/env"
 	"github.com/devlink-sh/devlink/cmd/git"
 	"github.com/devlink-sh/devlink/cmd/hive"
+	"github.com/devlink-sh/devlink/cmd/keys"
 	"github.com/devlink-sh/devlink/cmd/pair"
 	"github.com/devlink-sh/devlink/cmd/registry"
 	"github.com/spf13/cobra"
@@ -42,6 +43,7 @@ developer utilities.
   • git       - Share and connect to Git repositories
   • pair      - Share your localhost with teammates
   • env       - Share development environments
+  • keys      - Manage keys for recipient-pinned shares
   • db        - Database management and sharing
   • registry  - Docker registry management
 
@@ -71,4 +73,5 @@ func init() {
 	rootCmd.AddCommand(git.GitCmd)
 	rootCmd.AddCommand(directory.DirectoryCmd)
 	rootCmd.AddCommand(hive.HiveCmd)
+	rootCmd.AddCommand(keys.KeysCmd)
 }
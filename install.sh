@@ -28,14 +28,15 @@ esac
 # Download the appropriate binary
 curl -L -o devlink.tar.gz "https://github.com/devlink-sh/devlink/releases/latest/download/devlink_${OS}_${ARCH}.tar.gz"
 tar -xzf devlink.tar.gz
-chmod +x devlink
+chmod +x devlink git-remote-devlink
 
 # Install globally
 echo "📦 Installing globally to /usr/local/bin..."
-sudo cp devlink /usr/local/bin/
+# git-remote-devlink lets git clone and fetch devlink:// URLs
+sudo cp devlink git-remote-devlink /usr/local/bin/
 
 # Clean up
-rm devlink devlink.tar.gz
+rm devlink git-remote-devlink devlink.tar.gz
 
 # Setup instructions
 echo ""
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Endpoint is a local service address given on the command line: a port on
+// 127.0.0.1, a host:port, or a Unix domain socket written unix:///path.
+type Endpoint struct {
+	Network string // "tcp" or "unix"
+	Address string // host:port or socket path
+}
+
+// ParseEndpoint parses "5432", "host:5432" or "unix:///path/to/socket".
+func ParseEndpoint(s string) (Endpoint, error) {
+	if path, ok := strings.CutPrefix(s, "unix://"); ok {
+		if !strings.HasPrefix(path, "/") {
+			return Endpoint{}, fmt.Errorf("invalid socket %q, expected unix:///absolute/path", s)
+		}
+		return Endpoint{Network: "unix", Address: path}, nil
+	}
+	if !strings.Contains(s, ":") {
+		s = "127.0.0.1:" + s
+	}
+	_, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return Endpoint{}, fmt.Errorf("invalid address %q, expected a port, host:port or unix:///path", s)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return Endpoint{}, fmt.Errorf("invalid port %q", port)
+	}
+	return Endpoint{Network: "tcp", Address: s}, nil
+}
+
+func (e Endpoint) String() string {
+	if e.Network == "unix" {
+		return "unix://" + e.Address
+	}
+	return e.Address
+}
+
+// IsUnix reports whether the endpoint is a Unix domain socket.
+func (e Endpoint) IsUnix() bool {
+	return e.Network == "unix"
+}
+
+// Dial connects to the endpoint.
+func (e Endpoint) Dial() (net.Conn, error) {
+	return net.Dial(e.Network, e.Address)
+}
+
+// Listen listens on the endpoint. A socket file left behind by a previous
+// run is replaced, but only if nothing answers on it anymore.
+func (e Endpoint) Listen() (net.Listener, error) {
+	if e.IsUnix() {
+		if info, err := os.Stat(e.Address); err == nil {
+			if info.Mode()&os.ModeSocket == 0 {
+				return nil, fmt.Errorf("%s exists and is not a socket", e.Address)
+			}
+			if c, err := net.Dial("unix", e.Address); err == nil {
+				c.Close()
+				return nil, fmt.Errorf("%s is in use", e.Address)
+			}
+			if err := os.Remove(e.Address); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+	return net.Listen(e.Network, e.Address)
+}
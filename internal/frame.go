@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// A frame wraps a single file-like transfer so the receiver can tell a
+// complete payload from a truncated or corrupted one:
+//
+//	"DLFRAME1" | uint32 header length | JSON FrameHeader
+//	{ uint32 chunk length | chunk bytes }...  | uint32 0
+//	uint64 total length | SHA-256 of the payload
+//
+// The body is chunked so senders can stream payloads of unknown size.
+var frameMagic = []byte("DLFRAME1")
+
+const (
+	frameChunkSize    = 64 * 1024
+	maxFrameHeaderLen = 1 << 20
+)
+
+// ErrFrameCorrupt is returned when a received payload does not match the
+// length or digest announced by the sender.
+var ErrFrameCorrupt = errors.New("transfer corrupted: checksum mismatch")
+
+// FrameHeader describes the payload of a frame.
+type FrameHeader struct {
+	Kind string `json:"kind"`
+	Name string `json:"name,omitempty"`
+	// Size is the payload length, or -1 if it is not known up front.
+	Size int64             `json:"size"`
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// WriteFrame streams r to w as a single frame and returns the number of
+// payload bytes written.
+func WriteFrame(w io.Writer, header FrameHeader, r io.Reader) (int64, error) {
+	bw := bufio.NewWriterSize(w, frameChunkSize+4)
+
+	hdr, err := json.Marshal(header)
+	if err != nil {
+		return 0, err
+	}
+	bw.Write(frameMagic)
+	binary.Write(bw, binary.BigEndian, uint32(len(hdr)))
+	bw.Write(hdr)
+
+	digest := sha256.New()
+	buf := make([]byte, frameChunkSize)
+	var total int64
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			digest.Write(buf[:n])
+			total += int64(n)
+			binary.Write(bw, binary.BigEndian, uint32(n))
+			if _, err := bw.Write(buf[:n]); err != nil {
+				return total, err
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return total, rerr
+		}
+	}
+
+	binary.Write(bw, binary.BigEndian, uint32(0))
+	binary.Write(bw, binary.BigEndian, uint64(total))
+	bw.Write(digest.Sum(nil))
+	return total, bw.Flush()
+}
+
+// FrameReader reads the payload of a frame. Read only returns io.EOF once
+// the whole payload has arrived and its length and digest have been
+// verified; otherwise it fails with ErrFrameCorrupt or io.ErrUnexpectedEOF.
+type FrameReader struct {
+	Header FrameHeader
+
+	r         *bufio.Reader
+	digest    hash.Hash
+	remaining uint32 // bytes left in the current chunk
+	total     int64
+	err       error // sticky once the frame ended or failed
+}
+
+// NewFrameReader reads and validates the frame header from r.
+func NewFrameReader(r io.Reader) (*FrameReader, error) {
+	br := bufio.NewReaderSize(r, frameChunkSize+4)
+
+	magic := make([]byte, len(frameMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, fmt.Errorf("reading transfer header: %w", unexpected(err))
+	}
+	if !bytes.Equal(magic, frameMagic) {
+		return nil, errors.New("peer did not send a devlink transfer (version mismatch?)")
+	}
+	var n uint32
+	if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+		return nil, fmt.Errorf("reading transfer header: %w", unexpected(err))
+	}
+	if n > maxFrameHeaderLen {
+		return nil, errors.New("transfer header too large")
+	}
+	hdr := make([]byte, n)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, fmt.Errorf("reading transfer header: %w", unexpected(err))
+	}
+
+	fr := &FrameReader{r: br, digest: sha256.New()}
+	if err := json.Unmarshal(hdr, &fr.Header); err != nil {
+		return nil, fmt.Errorf("invalid transfer header: %w", err)
+	}
+	return fr, nil
+}
+
+func (fr *FrameReader) Read(p []byte) (int, error) {
+	if fr.err != nil {
+		return 0, fr.err
+	}
+	if fr.remaining == 0 {
+		if err := binary.Read(fr.r, binary.BigEndian, &fr.remaining); err != nil {
+			fr.err = unexpected(err)
+			return 0, fr.err
+		}
+		if fr.remaining == 0 {
+			fr.err = fr.verify()
+			return 0, fr.err
+		}
+	}
+	if uint32(len(p)) > fr.remaining {
+		p = p[:fr.remaining]
+	}
+	n, err := fr.r.Read(p)
+	fr.digest.Write(p[:n])
+	fr.total += int64(n)
+	fr.remaining -= uint32(n)
+	if err != nil {
+		fr.err = unexpected(err)
+		return n, fr.err
+	}
+	return n, nil
+}
+
+// verify checks the trailer once the terminating empty chunk was read.
+func (fr *FrameReader) verify() error {
+	var total uint64
+	if err := binary.Read(fr.r, binary.BigEndian, &total); err != nil {
+		return unexpected(err)
+	}
+	sum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(fr.r, sum); err != nil {
+		return unexpected(err)
+	}
+	if int64(total) != fr.total || (fr.Header.Size >= 0 && fr.Header.Size != fr.total) {
+		return fmt.Errorf("%w: got %d bytes, expected %d", ErrFrameCorrupt, fr.total, total)
+	}
+	if !bytes.Equal(sum, fr.digest.Sum(nil)) {
+		return ErrFrameCorrupt
+	}
+	return io.EOF
+}
+
+// Sum returns the hex SHA-256 of the payload read so far.
+func (fr *FrameReader) Sum() string {
+	return fmt.Sprintf("%x", fr.digest.Sum(nil))
+}
+
+// SaveFrame reads a frame from r into dest and returns the frame (for its
+// header and checksum) and the payload length. dest is only created once the
+// whole payload has arrived intact; partial data is removed. An empty dest
+// names the file after the frame header.
+func SaveFrame(r io.Reader, dest string) (*FrameReader, int64, error) {
+	frame, err := NewFrameReader(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if dest == "" {
+		dest = filepath.Base(frame.Header.Name)
+		if dest == "." || dest == string(filepath.Separator) {
+			return nil, 0, fmt.Errorf("frame has no file name, pick a destination")
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".partial-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	n, err := io.Copy(tmp, frame)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, n, fmt.Errorf("transfer failed after %d bytes, partial file removed: %w", n, err)
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return nil, n, err
+	}
+	return frame, n, nil
+}
+
+// unexpected turns a clean EOF in the middle of a frame into an error.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,304 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// sealedMagic prefixes every payload produced by Seal so receivers can tell
+// recipient-pinned shares apart from plain ones.
+var sealedMagic = []byte("DEVLINK-SEALED-V1\n")
+
+// ErrNotRecipient is returned by Open when the payload was not sealed for us.
+var ErrNotRecipient = errors.New("this share was pinned to other recipients")
+
+// ConfigDir returns the devlink configuration directory, creating it if needed.
+func ConfigDir() (string, error) {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(base, "devlink")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func keyPath() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "key"), nil
+}
+
+func contactsPath() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "contacts.json"), nil
+}
+
+// GenerateKey creates and stores a new local X25519 keypair. It refuses to
+// replace an existing key unless force is set.
+func GenerateKey(force bool) (*ecdh.PrivateKey, error) {
+	path, err := keyPath()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil && !force {
+		return nil, fmt.Errorf("a key already exists at %s", path)
+	}
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	data := base64.StdEncoding.EncodeToString(priv.Bytes()) + "\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
+
+// LoadKey reads the local private key created by GenerateKey.
+func LoadKey() (*ecdh.PrivateKey, error) {
+	path, err := keyPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, errors.New("no local key found, run 'devlink keys init' first")
+	}
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, fmt.Errorf("corrupt key file %s: %w", path, err)
+	}
+	return ecdh.X25519().NewPrivateKey(raw)
+}
+
+// EncodePublicKey returns the shareable text form of a public key.
+func EncodePublicKey(pub *ecdh.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(pub.Bytes())
+}
+
+// ParsePublicKey parses a public key produced by EncodePublicKey.
+func ParsePublicKey(s string) (*ecdh.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	pub, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	return pub, nil
+}
+
+// Fingerprint returns a short identifier for a public key.
+func Fingerprint(pub *ecdh.PublicKey) string {
+	sum := sha256.Sum256(pub.Bytes())
+	return hex.EncodeToString(sum[:8])
+}
+
+// LoadContacts returns the name -> public key contacts list.
+func LoadContacts() (map[string]string, error) {
+	path, err := contactsPath()
+	if err != nil {
+		return nil, err
+	}
+	contacts := map[string]string{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return contacts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &contacts); err != nil {
+		return nil, fmt.Errorf("corrupt contacts file %s: %w", path, err)
+	}
+	return contacts, nil
+}
+
+// SaveContacts writes the contacts list.
+func SaveContacts(contacts map[string]string) error {
+	path, err := contactsPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(contacts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// ResolveRecipients looks up contact names and returns their public keys.
+func ResolveRecipients(names []string) ([]*ecdh.PublicKey, error) {
+	contacts, err := LoadContacts()
+	if err != nil {
+		return nil, err
+	}
+	var keys []*ecdh.PublicKey
+	for _, name := range names {
+		encoded, ok := contacts[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown recipient %q, add them with 'devlink keys add %s <pubkey>'", name, name)
+		}
+		pub, err := ParsePublicKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("contact %s: %w", name, err)
+		}
+		keys = append(keys, pub)
+	}
+	return keys, nil
+}
+
+type sealedRecipient struct {
+	ID  string `json:"id"`
+	Key []byte `json:"key"`
+}
+
+type sealedEnvelope struct {
+	Ephemeral  []byte            `json:"epk"`
+	Recipients []sealedRecipient `json:"recipients"`
+	Payload    []byte            `json:"payload"`
+}
+
+// Seal encrypts plaintext so that only the given recipients can Open it. The
+// payload is encrypted once with a random content key, which is then wrapped
+// for each recipient using X25519 with an ephemeral sender key.
+func Seal(plaintext []byte, recipients []*ecdh.PublicKey) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients")
+	}
+	contentKey := make([]byte, 32)
+	if _, err := rand.Read(contentKey); err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	env := sealedEnvelope{Ephemeral: ephemeral.PublicKey().Bytes()}
+	if env.Payload, err = aesSeal(contentKey, plaintext); err != nil {
+		return nil, err
+	}
+	for _, pub := range recipients {
+		shared, err := ephemeral.ECDH(pub)
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := aesSeal(wrapKey(shared, env.Ephemeral, pub.Bytes()), contentKey)
+		if err != nil {
+			return nil, err
+		}
+		env.Recipients = append(env.Recipients, sealedRecipient{ID: Fingerprint(pub), Key: wrapped})
+	}
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, sealedMagic...), data...), nil
+}
+
+// IsSealed reports whether data was produced by Seal.
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealedMagic)
+}
+
+// Open decrypts a payload produced by Seal with the local private key.
+func Open(data []byte, priv *ecdh.PrivateKey) ([]byte, error) {
+	if !IsSealed(data) {
+		return nil, errors.New("payload is not sealed")
+	}
+	var env sealedEnvelope
+	if err := json.Unmarshal(data[len(sealedMagic):], &env); err != nil {
+		return nil, fmt.Errorf("corrupt sealed payload: %w", err)
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(env.Ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt sealed payload: %w", err)
+	}
+
+	pub := priv.PublicKey()
+	id := Fingerprint(pub)
+	for _, r := range env.Recipients {
+		if r.ID != id {
+			continue
+		}
+		shared, err := priv.ECDH(ephemeral)
+		if err != nil {
+			return nil, err
+		}
+		contentKey, err := aesOpen(wrapKey(shared, env.Ephemeral, pub.Bytes()), r.Key)
+		if err != nil {
+			return nil, ErrNotRecipient
+		}
+		return aesOpen(contentKey, env.Payload)
+	}
+	return nil, ErrNotRecipient
+}
+
+// wrapKey derives the per-recipient key-encryption key.
+func wrapKey(shared, ephemeral, recipient []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("devlink-seal-v1"))
+	h.Write(shared)
+	h.Write(ephemeral)
+	h.Write(recipient)
+	return h.Sum(nil)
+}
+
+// aesSeal encrypts with AES-256-GCM and prepends the random nonce.
+func aesSeal(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func aesOpen(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed payload too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
@@ -5,10 +5,14 @@ import (
 	"net"
 )
 
-// closeWrite attempts a half-close on TCP; no-op for non-TCP conns.
+// closeWrite attempts a half-close on TCP and Unix sockets; no-op for
+// other conns.
 func closeWrite(c net.Conn) {
-	if tc, ok := c.(*net.TCPConn); ok {
-		_ = tc.CloseWrite()
+	switch sc := c.(type) {
+	case *net.TCPConn:
+		_ = sc.CloseWrite()
+	case *net.UnixConn:
+		_ = sc.CloseWrite()
 	}
 }
 
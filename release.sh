@@ -13,12 +13,14 @@ mkdir -p "$DIST_DIR"
 # Build for macOS Intel (amd64)
 echo "🔨 Building for macOS amd64..."
 GOOS=darwin GOARCH=amd64 go build -o "$DIST_DIR/devlink" ./cmd/devlink
-tar -czf "$DIST_DIR/devlink_${VERSION}_darwin_amd64.tar.gz" -C "$DIST_DIR" devlink
+GOOS=darwin GOARCH=amd64 go build -o "$DIST_DIR/git-remote-devlink" ./cmd/git-remote-devlink
+tar -czf "$DIST_DIR/devlink_${VERSION}_darwin_amd64.tar.gz" -C "$DIST_DIR" devlink git-remote-devlink
 
 # Build for macOS ARM (arm64)
 echo "🔨 Building for macOS arm64..."
 GOOS=darwin GOARCH=arm64 go build -o "$DIST_DIR/devlink" ./cmd/devlink
-tar -czf "$DIST_DIR/devlink_${VERSION}_darwin_arm64.tar.gz" -C "$DIST_DIR" devlink
+GOOS=darwin GOARCH=arm64 go build -o "$DIST_DIR/git-remote-devlink" ./cmd/git-remote-devlink
+tar -czf "$DIST_DIR/devlink_${VERSION}_darwin_arm64.tar.gz" -C "$DIST_DIR" devlink git-remote-devlink
 
 # Compute SHA256 checksums
 echo "🔑 Computing SHA256 checksums..."